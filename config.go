@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/heinrichgrt/m4areorg/reorg"
+)
+
+var configFile string
+
+// listValue is a comma separated list flag
+type listValue []string
+
+func (l *listValue) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *listValue) Set(s string) error {
+	*l = reorg.SplitList(s)
+	return nil
+}
+
+// newFlagSet binds all command line flags, the config flags take their
+// defaults from cfg, so only flags given on the command line change it
+func newFlagSet(cfg *reorg.Config) *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags]\n       %s fix [flags]\n       %s config show [flags]\n", os.Args[0], os.Args[0], os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&userLogLevel, "loglevel", "warn", "Loglevel: [error | warn | info | debug | trace]")
+	fs.StringVar(&sourceDirectory, "directory", "", "Directory to parse")
+	fs.BoolVar(&dryRun, "dry-run", false, "Print the join plan for each book, do not run any tools")
+	fs.IntVar(&jobs, "jobs", 1, "Number of books joined at the same time")
+	fs.StringVar(&reportFile, "report", "", "Write the scan and integrity report to this file, .csv or .json")
+	fs.BoolVar(&force, "force", false, "Join all books again, even if the journal has them done")
+	fs.BoolVar(&assumeYes, "yes", false, "fix: write the fixes without asking")
+	fs.BoolVar(&sidecar, "sidecar", false, "fix: write the fixes to "+reorg.OverrideFile+" in the folder instead of the tags")
+	fs.StringVar(&configFile, "config", os.Getenv(reorg.EnvPrefix+"CONFIG"), "Config file (default "+reorg.DefaultConfigFile()+")")
+
+	fs.IntVar(&cfg.AlreadyLongEnough, "already-long-enough", cfg.AlreadyLongEnough, "Skip books with tracks longer than this (ms)")
+	fs.IntVar(&cfg.MaxDuration, "max-duration", cfg.MaxDuration, "Max length of a joined part (ms)")
+	fs.StringVar(&cfg.TmpDir, "tmp-dir", cfg.TmpDir, "Root of the per book temp dirs")
+	fs.BoolVar(&cfg.KeepTemp, "keep-temp", cfg.KeepTemp, "Keep the per book temp dirs with concat lists and metadata")
+	fs.StringVar(&cfg.ToolBinPath, "tool-bin-path", cfg.ToolBinPath, "Where to find ffmpeg")
+	fs.StringVar(&cfg.ChapterTitle, "chapter-title", cfg.ChapterTitle, "Title of the chapters, followed by the number (default the chapter label of the language)")
+	fs.StringVar(&cfg.Lang, "lang", cfg.Lang, "Language of the part and chapter labels: de, en, fr, one from the config file or auto for the language tag of the book")
+	fs.StringVar(&cfg.ChapterTemplate, "chapter-template", cfg.ChapterTemplate, "Go template of the chapter titles with .Title .Index .Disk .TrackOnDisk .Part .Filename .Label (default: track titles if distinct, else numbered)")
+	fs.StringVar(&cfg.SourceChapters, "source-chapters", cfg.SourceChapters, "Chapters inside the source files: prefix, flatten or ignore")
+	fs.StringVar(&cfg.TargetDir, "target-dir", cfg.TargetDir, "Where the joined books go")
+	fs.BoolVar(&cfg.Strict, "strict", cfg.Strict, "Use the tags only, do not infer missing track and disk numbers, author or book from file and folder names")
+	fs.Var((*listValue)(&cfg.PathPatterns), "path-patterns", "Comma separated folder patterns author and book are taken from if the tags are empty, e.g. {author}/{book}")
+	fs.BoolVar(&cfg.DeepCheck, "deep-check", cfg.DeepCheck, "Decode all tracks with ffmpeg before joining, reject books with broken files")
+	fs.IntVar(&cfg.DurationTolerance, "duration-tolerance", cfg.DurationTolerance, "Allowed difference of decoded and container length (ms)")
+	fs.StringVar(&cfg.FormatPolicy, "format-policy", cfg.FormatPolicy, "Tracks of a book in different formats: reject the book or normalize them to the format of most tracks")
+	fs.Var((*listValue)(&cfg.TrackPolicy), "track-policy", "Comma separated track check policies: strict, or any of allow-gaps, allow-extras, ignore-maxtrack")
+	fs.Var((*listValue)(&cfg.GroupBy), "group-by", "Comma separated tags the author is taken from, first set wins: artist, albumartist, composer")
+	fs.StringVar(&cfg.NarratorTag, "narrator-tag", cfg.NarratorTag, "Where the narrator goes in the output: composer, narrator or none")
+	return fs
+}
+
+// loadConfig builds the effective config from, in order of precedence:
+// command line flags, M4AREORG_* environment, the config file and the
+// built in defaults. It returns the remaining arguments.
+func loadConfig(args []string) (*reorg.Config, []string, error) {
+	// first pass is only to find the config file
+	newFlagSet(reorg.DefaultConfig()).Parse(args)
+
+	cfg := reorg.DefaultConfig()
+	name := configFile
+	if name == "" {
+		name = reorg.DefaultConfigFile()
+		if _, err := os.Stat(name); err != nil {
+			name = ""
+		}
+	}
+	if name != "" {
+		if err := cfg.LoadFile(name); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := cfg.ApplyEnv(); err != nil {
+		return nil, nil, err
+	}
+	fs := newFlagSet(cfg)
+	fs.Parse(args)
+	return cfg, fs.Args(), nil
+}
@@ -0,0 +1,259 @@
+// Package ffmeta reads and writes the FFMETADATA files of ffmpeg: the
+// global tags, one section per stream and the chapters.
+//
+// Keys and values are escaped as ffmpeg expects, '=', ';', '#', '\' and
+// newlines are preceded by a backslash.
+package ffmeta
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Header is the first line of every FFMETADATA file
+const Header = ";FFMETADATA1"
+
+// Tag is one key=value line
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// Tags are the tags of a section in file order
+type Tags []Tag
+
+// Get returns the value of the first tag of key, keys are not case
+// sensitive like in ffmpeg
+func (t Tags) Get(key string) (string, bool) {
+	for _, tag := range t {
+		if strings.EqualFold(tag.Key, key) {
+			return tag.Value, true
+		}
+	}
+	return "", false
+}
+
+// Set replaces the value of key or adds it at the end
+func (t *Tags) Set(key string, value string) {
+	for i := range *t {
+		if strings.EqualFold((*t)[i].Key, key) {
+			(*t)[i].Value = value
+			return
+		}
+	}
+	*t = append(*t, Tag{key, value})
+}
+
+// Delete drops all tags of key
+func (t *Tags) Delete(key string) {
+	kept := (*t)[:0]
+	for _, tag := range *t {
+		if !strings.EqualFold(tag.Key, key) {
+			kept = append(kept, tag)
+		}
+	}
+	*t = kept
+}
+
+// Chapter is a [CHAPTER] section, Start and End are in units of
+// TimeBase, e.g. 1/1000 for ms
+type Chapter struct {
+	TimeBase string
+	Start    int64
+	End      int64
+	Tags     Tags
+}
+
+// Metadata is the content of an FFMETADATA file
+type Metadata struct {
+	Global   Tags
+	Streams  []Tags
+	Chapters []Chapter
+}
+
+// Escape quotes the special characters of s
+func Escape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '=', ';', '#', '\\', '\n':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func writeTags(w *bufio.Writer, tags Tags) {
+	for _, t := range tags {
+		w.WriteString(Escape(t.Key) + "=" + Escape(t.Value) + "\n")
+	}
+}
+
+// Write encodes m in the FFMETADATA format
+func (m *Metadata) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(Header + "\n")
+	writeTags(bw, m.Global)
+	for _, s := range m.Streams {
+		bw.WriteString("[STREAM]\n")
+		writeTags(bw, s)
+	}
+	for _, c := range m.Chapters {
+		tb := c.TimeBase
+		if tb == "" {
+			tb = "1/1000"
+		}
+		fmt.Fprintf(bw, "[CHAPTER]\nTIMEBASE=%s\nSTART=%d\nEND=%d\n", tb, c.Start, c.End)
+		writeTags(bw, c.Tags)
+	}
+	return bw.Flush()
+}
+
+// String returns the encoded metadata
+func (m *Metadata) String() string {
+	var b bytes.Buffer
+	m.Write(&b)
+	return b.String()
+}
+
+// WriteFile writes m to the file name
+func (m *Metadata) WriteFile(name string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	err = m.Write(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// line is a line of the file with the escapes resolved, sep is the
+// position of the first unescaped '=', -1 if there is none
+type line struct {
+	text string
+	sep  int
+	no   int
+}
+
+// splitLines splits data into lines, an escaped newline is part of the
+// line. Comments and empty lines are dropped.
+func splitLines(data string) []line {
+	var lines []line
+	var b strings.Builder
+	sep := -1
+	no, start := 1, 1
+	comment := false
+	atStart := true
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case atStart && (c == ';' || c == '#'):
+			comment = true
+		case c == '\\' && i+1 < len(data):
+			i++
+			if data[i] == '\n' {
+				no++
+			}
+			if !comment {
+				b.WriteByte(data[i])
+			}
+		case c == '\n':
+			if !comment && b.Len() > 0 {
+				lines = append(lines, line{strings.TrimSuffix(b.String(), "\r"), sep, start})
+			}
+			b.Reset()
+			sep = -1
+			comment = false
+			no++
+			start = no
+			atStart = true
+			continue
+		case c == '=' && sep < 0 && !comment:
+			sep = b.Len()
+			b.WriteByte(c)
+		case !comment:
+			b.WriteByte(c)
+		}
+		atStart = false
+	}
+	if !comment && b.Len() > 0 {
+		lines = append(lines, line{strings.TrimSuffix(b.String(), "\r"), sep, start})
+	}
+	return lines
+}
+
+// Parse reads an FFMETADATA file
+func Parse(r io.Reader) (*Metadata, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	s := string(data)
+	if !strings.HasPrefix(s, Header) {
+		return nil, fmt.Errorf("ffmeta: missing %v header", Header)
+	}
+	m := &Metadata{}
+	tags := &m.Global
+	var chapter *Chapter
+	for _, l := range splitLines(s) {
+		switch {
+		case l.sep < 0 && l.text == "[STREAM]":
+			m.Streams = append(m.Streams, Tags{})
+			tags = &m.Streams[len(m.Streams)-1]
+			chapter = nil
+			continue
+		case l.sep < 0 && l.text == "[CHAPTER]":
+			m.Chapters = append(m.Chapters, Chapter{TimeBase: "1/1000"})
+			chapter = &m.Chapters[len(m.Chapters)-1]
+			tags = &chapter.Tags
+			continue
+		case l.sep < 0:
+			return nil, fmt.Errorf("ffmeta: line %d: expected key=value: %q", l.no, l.text)
+		}
+		key, value := l.text[:l.sep], l.text[l.sep+1:]
+		if chapter != nil {
+			var err error
+			switch key {
+			case "TIMEBASE":
+				chapter.TimeBase = value
+				continue
+			case "START":
+				chapter.Start, err = strconv.ParseInt(value, 10, 64)
+			case "END":
+				chapter.End, err = strconv.ParseInt(value, 10, 64)
+			default:
+				*tags = append(*tags, Tag{key, value})
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("ffmeta: line %d: %w", l.no, err)
+			}
+			continue
+		}
+		*tags = append(*tags, Tag{key, value})
+	}
+	return m, nil
+}
+
+// ReadFile parses the file name
+func ReadFile(name string) (*Metadata, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", name, err)
+	}
+	return m, nil
+}
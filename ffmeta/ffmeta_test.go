@@ -0,0 +1,129 @@
+package ffmeta
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var special = "a=b;c#d\\e\nf"
+
+func TestRoundTrip(t *testing.T) {
+	for name, m := range map[string]*Metadata{
+		"empty":  {},
+		"global": {Global: Tags{{"title", special}, {"artist", "Doe, John"}}},
+		"escaped keys": {Global: Tags{{"we=ird", "x"}, {"semi;colon", "y"}, {"#hash", "z"},
+			{"back\\slash", "\\"}, {"new\nline", "\n"}, {";starts", ";"}}},
+		"streams": {Streams: []Tags{{{"language", "deu"}}, {}, {{"title", special}}}},
+		"chapters": {Global: Tags{{"title", "Book"}}, Chapters: []Chapter{
+			{TimeBase: "1/1000", Start: 0, End: 1000, Tags: Tags{{"title", "Kap; 1"}}},
+			{TimeBase: "1/44100", Start: 44100, End: 88200, Tags: Tags{{"title", special}, {"k=ey", "v"}}},
+			{TimeBase: "1/1000", Start: 2000, End: 3000},
+		}},
+		"all": {Global: Tags{{"title", special}},
+			Streams:  []Tags{{{"language", "eng"}}},
+			Chapters: []Chapter{{TimeBase: "1/1000", Start: 0, End: 10, Tags: Tags{{"title", "#1"}}}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := m.String()
+			n, err := Parse(strings.NewReader(s))
+			if err != nil {
+				t.Fatalf("%v\n%s", err, s)
+			}
+			if !reflect.DeepEqual(norm(n), norm(m)) {
+				t.Errorf("got %#v\nwant %#v\nfrom %q", n, m, s)
+			}
+			if n.String() != s {
+				t.Errorf("re-encoded %q, want %q", n.String(), s)
+			}
+		})
+	}
+}
+
+// norm makes empty and nil tags equal
+func norm(m *Metadata) *Metadata {
+	c := *m
+	if len(c.Global) == 0 {
+		c.Global = nil
+	}
+	c.Streams = nil
+	for _, s := range m.Streams {
+		if len(s) == 0 {
+			s = nil
+		}
+		c.Streams = append(c.Streams, s)
+	}
+	c.Chapters = nil
+	for _, ch := range m.Chapters {
+		if len(ch.Tags) == 0 {
+			ch.Tags = nil
+		}
+		c.Chapters = append(c.Chapters, ch)
+	}
+	return &c
+}
+
+func TestEscape(t *testing.T) {
+	if e, want := Escape(special), "a\\=b\\;c\\#d\\\\e\\\nf"; e != want {
+		t.Errorf("Escape %q, want %q", e, want)
+	}
+	m := &Metadata{Global: Tags{{"k=1", special}}}
+	if s, want := m.String(), Header+"\nk\\=1=a\\=b\\;c\\#d\\\\e\\\nf\n"; s != want {
+		t.Errorf("String %q, want %q", s, want)
+	}
+}
+
+func TestParse(t *testing.T) {
+	m, err := Parse(strings.NewReader(Header + "\n# comment\n;comment=x\ntitle=a\\\nb\n\n" +
+		"[CHAPTER]\nSTART=5\nEND=7\ntitle=c\n[STREAM]\nlanguage=deu\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Metadata{Global: Tags{{"title", "a\nb"}},
+		Streams:  []Tags{{{"language", "deu"}}},
+		Chapters: []Chapter{{TimeBase: "1/1000", Start: 5, End: 7, Tags: Tags{{"title", "c"}}}}}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("got %#v, want %#v", m, want)
+	}
+	if v, ok := m.Global.Get("TITLE"); !ok || v != "a\nb" {
+		t.Errorf("Get TITLE %q %v", v, ok)
+	}
+	for _, bad := range []string{
+		"title=x\n",
+		Header + "\nno separator\n",
+		Header + "\n[CHAPTER]\nSTART=x\n",
+	} {
+		if _, err := Parse(strings.NewReader(bad)); err == nil {
+			t.Errorf("no error for %q", bad)
+		}
+	}
+}
+
+func TestFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "meta.txt")
+	m := &Metadata{Global: Tags{{"title", special}}}
+	if err := m.WriteFile(name); err != nil {
+		t.Fatal(err)
+	}
+	n, err := ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(n, m) {
+		t.Errorf("got %#v, want %#v", n, m)
+	}
+}
+
+func TestTags(t *testing.T) {
+	tags := Tags{{"Title", "a"}, {"artist", "b"}, {"TITLE", "c"}}
+	tags.Set("title", "d")
+	if v, _ := tags.Get("title"); v != "d" || len(tags) != 3 {
+		t.Errorf("Set: %v", tags)
+	}
+	tags.Set("genre", "e")
+	tags.Delete("title")
+	if want := (Tags{{"artist", "b"}, {"genre", "e"}}); !reflect.DeepEqual(tags, want) {
+		t.Errorf("Delete: %v, want %v", tags, want)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/heinrichgrt/m4areorg/reorg"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	assumeYes bool
+	sidecar   bool
+)
+
+// fixBooks proposes repaired disk and track numbers for the books, shows them
+// and writes them after confirmation. It returns false if nothing was
+// written and the run should stop.
+func fixBooks(ctx context.Context) bool {
+	lib, err := reorg.Scan(ctx, sourceDirectory, config)
+	if err != nil {
+		log.Errorf("cannot scan %v: %v", sourceDirectory, err)
+		os.Exit(1)
+	}
+	verdicts := lib.Verify(config)
+	fixes := []reorg.TrackFix{}
+	for _, auth := range lib.Authors() {
+		for _, book := range lib.Books(auth) {
+			v := verdicts.Get(auth, book)
+			if v.Reason == reorg.ReasonSingleFile || v.Reason == reorg.ReasonAlreadyLongEnough {
+				continue
+			}
+			f := reorg.ProposeFix(lib[auth][book], sidecar)
+			if len(f) == 0 {
+				continue
+			}
+			if v.OK() {
+				fmt.Printf("%s: %s\n", auth, book)
+			} else {
+				fmt.Printf("%s: %s (%s: %s)\n", auth, book, v.Reason, v.Detail)
+			}
+			for _, tf := range f {
+				fmt.Printf("  %s\n    - %v\n    + %v\n", tf.Filename, tf.Old, tf.New)
+			}
+			fixes = append(fixes, f...)
+		}
+	}
+	if len(fixes) == 0 {
+		fmt.Println("nothing to fix")
+		return true
+	}
+	if dryRun {
+		return false
+	}
+	target := "the tags"
+	if sidecar {
+		target = reorg.OverrideFile
+	}
+	if !assumeYes && !confirm(ctx, fmt.Sprintf("write %d fixes to %s?", len(fixes), target)) {
+		return false
+	}
+	failed := 0
+	for _, tf := range fixes {
+		if err := reorg.ApplyFix(tf, sidecar); err != nil {
+			log.Errorf("cannot fix %v: %v", tf.Filename, err)
+			failed++
+		}
+	}
+	fmt.Printf("%d fixes written, %d failed\n", len(fixes)-failed, failed)
+	return true
+}
+
+// confirm asks question on stdin, Ctrl-C while waiting is a no
+func confirm(ctx context.Context, question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answers := make(chan string, 1)
+	go func() {
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		answers <- answer
+	}()
+	select {
+	case <-ctx.Done():
+		fmt.Println()
+		return false
+	case answer := <-answers:
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			return true
+		}
+		return false
+	}
+}
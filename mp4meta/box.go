@@ -0,0 +1,162 @@
+package mp4meta
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// box is one MP4 atom. Containers have children, for all others data is
+// the payload. Full boxes which are containers (meta) keep their version
+// and flags in data.
+type box struct {
+	typ      string
+	data     []byte
+	children []*box
+}
+
+// containers are parsed into children, meta is a full box with 4 bytes
+// of version and flags before its children
+var containers = map[string]bool{
+	"moov": true, "trak": true, "mdia": true, "minf": true, "stbl": true,
+	"udta": true, "edts": true, "dinf": true, "meta": true, "ilst": true,
+	"tref": true,
+}
+
+var errShortBox = errors.New("mp4: box extends past its parent")
+
+// parseBoxes reads all boxes in buf, inIlst marks the items of an ilst
+// which are containers of data atoms
+func parseBoxes(buf []byte, inIlst bool) ([]*box, error) {
+	var boxes []*box
+	for len(buf) > 0 {
+		if len(buf) < 8 {
+			return nil, errShortBox
+		}
+		size := uint64(binary.BigEndian.Uint32(buf))
+		typ := string(buf[4:8])
+		hdr := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(buf))
+		case 1:
+			if len(buf) < 16 {
+				return nil, errShortBox
+			}
+			size = binary.BigEndian.Uint64(buf[8:])
+			hdr = 16
+		}
+		if size < hdr || size > uint64(len(buf)) {
+			return nil, errShortBox
+		}
+		b := &box{typ: typ}
+		payload := buf[hdr:size]
+		var err error
+		switch {
+		case typ == "meta" && len(payload) >= 8 && string(payload[4:8]) == "hdlr":
+			// QuickTime style meta without version and flags
+			b.children, err = parseBoxes(payload, false)
+		case typ == "meta":
+			if len(payload) < 4 {
+				return nil, errShortBox
+			}
+			b.data = append([]byte(nil), payload[:4]...)
+			b.children, err = parseBoxes(payload[4:], false)
+		case containers[typ]:
+			b.children, err = parseBoxes(payload, typ == "ilst")
+		case inIlst:
+			b.children, err = parseBoxes(payload, false)
+		default:
+			b.data = append([]byte(nil), payload...)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", typ, err)
+		}
+		boxes = append(boxes, b)
+		buf = buf[size:]
+	}
+	return boxes, nil
+}
+
+// size is the encoded size including the header
+func (b *box) size() uint64 {
+	s := uint64(8 + len(b.data))
+	for _, c := range b.children {
+		s += c.size()
+	}
+	if s > 0xffffffff {
+		s += 8
+	}
+	return s
+}
+
+func (b *box) write(w io.Writer) error {
+	s := b.size()
+	var hdr []byte
+	if s > 0xffffffff {
+		hdr = make([]byte, 16)
+		binary.BigEndian.PutUint32(hdr, 1)
+		binary.BigEndian.PutUint64(hdr[8:], s)
+	} else {
+		hdr = make([]byte, 8)
+		binary.BigEndian.PutUint32(hdr, uint32(s))
+	}
+	copy(hdr[4:8], b.typ)
+	if _, err := w.Write(hdr); err != nil {
+		return err
+	}
+	if _, err := w.Write(b.data); err != nil {
+		return err
+	}
+	for _, c := range b.children {
+		if err := c.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// child returns the first child of type typ
+func (b *box) child(typ string) *box {
+	for _, c := range b.children {
+		if c.typ == typ {
+			return c
+		}
+	}
+	return nil
+}
+
+// path follows the types down from b, nil if any is missing
+func (b *box) path(types ...string) *box {
+	for _, t := range types {
+		if b = b.child(t); b == nil {
+			return nil
+		}
+	}
+	return b
+}
+
+// remove drops all children of type typ
+func (b *box) remove(typ string) {
+	kept := b.children[:0]
+	for _, c := range b.children {
+		if c.typ != typ {
+			kept = append(kept, c)
+		}
+	}
+	b.children = kept
+}
+
+// walk calls fn for b and all boxes below
+func (b *box) walk(fn func(*box) error) error {
+	if err := fn(b); err != nil {
+		return err
+	}
+	for _, c := range b.children {
+		if err := c.walk(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
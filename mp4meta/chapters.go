@@ -0,0 +1,244 @@
+package mp4meta
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Chapter is a chapter mark of the file
+type Chapter struct {
+	Start time.Duration
+	Title string
+}
+
+// Chapters returns the Nero chapters (moov/udta/chpl) or, if there are
+// none, the QuickTime chapter track of the file. The chapters are in the
+// order of the file, nil if there are none.
+func (f *File) Chapters() ([]Chapter, error) {
+	if chpl := f.moov.path("udta", "chpl"); chpl != nil {
+		c, err := neroChapters(chpl.data)
+		if err != nil {
+			return nil, fmt.Errorf("%v: chpl: %w", f.name, err)
+		}
+		if len(c) > 0 {
+			return c, nil
+		}
+	}
+	c, err := f.quicktimeChapters()
+	if err != nil {
+		return nil, fmt.Errorf("%v: chapter track: %w", f.name, err)
+	}
+	return c, nil
+}
+
+// neroChapters decodes chpl: version and flags, 4 reserved bytes in
+// version 1, the count and per chapter the start in 100ns units and a
+// pascal string
+func neroChapters(d []byte) ([]Chapter, error) {
+	if len(d) < 5 {
+		return nil, errShortBox
+	}
+	p := 4
+	if d[0] == 1 {
+		p += 4
+	}
+	if len(d) < p+1 {
+		return nil, errShortBox
+	}
+	n := int(d[p])
+	p++
+	var c []Chapter
+	for i := 0; i < n; i++ {
+		if len(d) < p+9 {
+			return nil, errShortBox
+		}
+		start := binary.BigEndian.Uint64(d[p:])
+		l := int(d[p+8])
+		p += 9
+		if len(d) < p+l {
+			return nil, errShortBox
+		}
+		c = append(c, Chapter{Start: time.Duration(start) * 100, Title: string(d[p : p+l])})
+		p += l
+	}
+	return c, nil
+}
+
+// quicktimeChapters reads the samples of the text track the first audio
+// track refers to with tref/chap
+func (f *File) quicktimeChapters() ([]Chapter, error) {
+	var ids []uint32
+	for _, t := range f.moov.children {
+		if t.typ != "trak" || handler(t) != "soun" {
+			continue
+		}
+		if chap := t.path("tref", "chap"); chap != nil {
+			for i := 0; i+4 <= len(chap.data); i += 4 {
+				ids = append(ids, binary.BigEndian.Uint32(chap.data[i:]))
+			}
+			break
+		}
+	}
+	for _, id := range ids {
+		for _, t := range f.moov.children {
+			if t.typ == "trak" && trackID(t) == id && handler(t) == "text" {
+				return f.textSamples(t)
+			}
+		}
+	}
+	return nil, nil
+}
+
+func handler(trak *box) string {
+	hdlr := trak.path("mdia", "hdlr")
+	if hdlr == nil || len(hdlr.data) < 12 {
+		return ""
+	}
+	return string(hdlr.data[8:12])
+}
+
+func trackID(trak *box) uint32 {
+	tkhd := trak.child("tkhd")
+	if tkhd == nil || len(tkhd.data) < 24 {
+		return 0
+	}
+	off := 12 // version 0: 32 bit times
+	if tkhd.data[0] == 1 {
+		off = 20
+	}
+	return binary.BigEndian.Uint32(tkhd.data[off:])
+}
+
+// textSamples reads the samples of a text track, a 16 bit length and the
+// text each
+func (f *File) textSamples(trak *box) ([]Chapter, error) {
+	mdhd := trak.path("mdia", "mdhd")
+	stbl := trak.path("mdia", "minf", "stbl")
+	if mdhd == nil || stbl == nil || len(mdhd.data) < 24 {
+		return nil, errShortBox
+	}
+	off := 12 // version 0: 32 bit times
+	if mdhd.data[0] == 1 {
+		off = 20
+	}
+	timescale := int64(binary.BigEndian.Uint32(mdhd.data[off:]))
+	if timescale == 0 {
+		return nil, errors.New("mp4: time scale 0")
+	}
+	starts, err := sampleTimes(stbl.child("stts"))
+	if err != nil {
+		return nil, err
+	}
+	offsets, sizes, err := sampleLocations(stbl)
+	if err != nil {
+		return nil, err
+	}
+	fh, err := os.Open(f.name)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	var c []Chapter
+	for i := 0; i < len(offsets) && i < len(starts); i++ {
+		if sizes[i] < 2 {
+			continue
+		}
+		buf := make([]byte, sizes[i])
+		if _, err := fh.ReadAt(buf, offsets[i]); err != nil {
+			return nil, err
+		}
+		l := int(binary.BigEndian.Uint16(buf))
+		if l > len(buf)-2 {
+			l = len(buf) - 2
+		}
+		c = append(c, Chapter{
+			Start: time.Duration(starts[i] * int64(time.Second) / timescale),
+			Title: string(buf[2 : 2+l]),
+		})
+	}
+	return c, nil
+}
+
+// sampleTimes returns the start of every sample in the track time scale
+func sampleTimes(stts *box) ([]int64, error) {
+	if stts == nil || len(stts.data) < 8 {
+		return nil, errShortBox
+	}
+	n := int(binary.BigEndian.Uint32(stts.data[4:]))
+	if len(stts.data) < 8+8*n {
+		return nil, errShortBox
+	}
+	var t int64
+	var starts []int64
+	for i := 0; i < n; i++ {
+		count := int(binary.BigEndian.Uint32(stts.data[8+8*i:]))
+		delta := int64(binary.BigEndian.Uint32(stts.data[12+8*i:]))
+		for j := 0; j < count; j++ {
+			starts = append(starts, t)
+			t += delta
+		}
+	}
+	return starts, nil
+}
+
+// sampleLocations returns the file offset and size of every sample from
+// stsz, stsc and stco or co64
+func sampleLocations(stbl *box) ([]int64, []int, error) {
+	stsz, stsc := stbl.child("stsz"), stbl.child("stsc")
+	if stsz == nil || stsc == nil || len(stsz.data) < 12 || len(stsc.data) < 8 {
+		return nil, nil, errShortBox
+	}
+	fixed := int(binary.BigEndian.Uint32(stsz.data[4:]))
+	n := int(binary.BigEndian.Uint32(stsz.data[8:]))
+	if fixed == 0 && len(stsz.data) < 12+4*n {
+		return nil, nil, errShortBox
+	}
+	sizes := make([]int, n)
+	for i := range sizes {
+		sizes[i] = fixed
+		if fixed == 0 {
+			sizes[i] = int(binary.BigEndian.Uint32(stsz.data[12+4*i:]))
+		}
+	}
+	var chunks []int64
+	if stco := stbl.child("stco"); stco != nil && len(stco.data) >= 8 {
+		m := int(binary.BigEndian.Uint32(stco.data[4:]))
+		for i := 0; i < m && 12+4*i <= len(stco.data); i++ {
+			chunks = append(chunks, int64(binary.BigEndian.Uint32(stco.data[8+4*i:])))
+		}
+	} else if co64 := stbl.child("co64"); co64 != nil && len(co64.data) >= 8 {
+		m := int(binary.BigEndian.Uint32(co64.data[4:]))
+		for i := 0; i < m && 16+8*i <= len(co64.data); i++ {
+			chunks = append(chunks, int64(binary.BigEndian.Uint64(co64.data[8+8*i:])))
+		}
+	}
+	entries := int(binary.BigEndian.Uint32(stsc.data[4:]))
+	if len(stsc.data) < 8+12*entries {
+		return nil, nil, errShortBox
+	}
+	offsets := make([]int64, 0, n)
+	s := 0
+	for e := 0; e < entries; e++ {
+		first := int(binary.BigEndian.Uint32(stsc.data[8+12*e:]))
+		if first < 1 {
+			return nil, nil, errors.New("mp4: stsc chunk 0")
+		}
+		perChunk := int(binary.BigEndian.Uint32(stsc.data[12+12*e:]))
+		last := len(chunks) + 1
+		if e+1 < entries {
+			last = int(binary.BigEndian.Uint32(stsc.data[8+12*(e+1):]))
+		}
+		for ch := first; ch < last && ch-1 < len(chunks); ch++ {
+			o := chunks[ch-1]
+			for k := 0; k < perChunk && s < n; k++ {
+				offsets = append(offsets, o)
+				o += int64(sizes[s])
+				s++
+			}
+		}
+	}
+	return offsets, sizes[:len(offsets)], nil
+}
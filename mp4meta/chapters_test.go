@@ -0,0 +1,191 @@
+package mp4meta
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// chpl returns the payload of a Nero chapter box of version v
+func chpl(v byte, c ...Chapter) []byte {
+	d := []byte{v, 0, 0, 0}
+	if v == 1 {
+		d = append(d, 0, 0, 0, 0)
+	}
+	d = append(d, byte(len(c)))
+	for _, ch := range c {
+		d = append(d, be64(uint64(ch.Start/100))...)
+		d = append(d, byte(len(ch.Title)))
+		d = append(d, ch.Title...)
+	}
+	return d
+}
+
+var testChapters = []Chapter{{0, "Anfang"}, {90 * time.Second, "Mitte"}, {3 * time.Minute, ""}}
+
+func TestNeroChapters(t *testing.T) {
+	for _, v := range []byte{0, 1} {
+		c, err := neroChapters(chpl(v, testChapters...))
+		if err != nil || !reflect.DeepEqual(c, testChapters) {
+			t.Errorf("version %d: %v %v", v, c, err)
+		}
+		if c, err := neroChapters(chpl(v)); err != nil || c != nil {
+			t.Errorf("version %d without chapters: %v %v", v, c, err)
+		}
+		d := chpl(v, testChapters...)
+		for _, n := range []int{3, len(d) - 20, len(d) - 1} {
+			if _, err := neroChapters(d[:n]); err == nil {
+				t.Errorf("version %d cut at %d: no error", v, n)
+			}
+		}
+	}
+}
+
+func hdlrBox(h string) []byte {
+	return mkBox("hdlr", make([]byte, 8), []byte(h), make([]byte, 13))
+}
+
+// textTrack describes the sample tables of a QuickTime chapter track
+type textTrack struct {
+	name    string
+	co64    bool
+	fixed   bool // one size for all samples in stsz
+	perLast bool // stsc says the last chunk has one sample
+	mdhdV1  bool
+}
+
+// build returns a file with an audio track referring to a text track
+// with the samples, all in one mdat, each pair of samples one chunk
+func (tt textTrack) build(samples []string, starts []uint32, timescale uint32) []byte {
+	ftyp := mkBox("ftyp", []byte("M4A \x00\x00\x02\x00isomiso2"))
+	var data [][]byte
+	for _, s := range samples {
+		data = append(data, append([]byte{0, byte(len(s))}, s...))
+	}
+	payload := bytes.Join(data, nil)
+	moov := func(first uint64) []byte {
+		var stsz []byte
+		if tt.fixed {
+			stsz = mkBox("stsz", be32(0, uint32(len(data[0])), uint32(len(data))))
+		} else {
+			sizes := be32(0, 0, uint32(len(data)))
+			for _, d := range data {
+				sizes = append(sizes, be32(uint32(len(d)))...)
+			}
+			stsz = mkBox("stsz", sizes)
+		}
+		var offsets []uint64
+		o := first
+		for i := 0; i < len(data); i += 2 {
+			offsets = append(offsets, o)
+			o += uint64(len(data[i]))
+			if i+1 < len(data) {
+				o += uint64(len(data[i+1]))
+			}
+		}
+		stsc := mkBox("stsc", be32(0, 1, 1, 2, 1))
+		if tt.perLast {
+			stsc = mkBox("stsc", be32(0, 2, 1, 2, 1, uint32(len(offsets)), 1, 1))
+		}
+		var co []byte
+		if tt.co64 {
+			co = mkBox("co64", be32(0, uint32(len(offsets))), be64(offsets...))
+		} else {
+			b := be32(0, uint32(len(offsets)))
+			for _, o := range offsets {
+				b = append(b, be32(uint32(o))...)
+			}
+			co = mkBox("stco", b)
+		}
+		stts := be32(0, uint32(len(starts)))
+		for i := range starts {
+			d := uint32(1000)
+			if i+1 < len(starts) {
+				d = starts[i+1] - starts[i]
+			}
+			stts = append(stts, be32(1, d)...)
+		}
+		mdhd := mkBox("mdhd", be32(0, 0, 0, timescale, 0, 0))
+		if tt.mdhdV1 {
+			mdhd = mkBox("mdhd", []byte{1, 0, 0, 0}, be64(0, 0), be32(timescale), be64(0), be32(0))
+		}
+		audio := mkBox("trak", mkBox("tkhd", be32(0, 0, 0, 1, 0, 0)), mkBox("tref", mkBox("chap", be32(2))),
+			mkBox("mdia", hdlrBox("soun")))
+		text := mkBox("trak", mkBox("tkhd", be32(0, 0, 0, 2, 0, 0)), mkBox("mdia", mdhd, hdlrBox("text"),
+			mkBox("minf", mkBox("stbl", mkBox("stts", stts), stsz, stsc, co))))
+		return mkBox("moov", mkBox("mvhd", make([]byte, 100)), audio, text)
+	}
+	size := len(moov(0))
+	return bytes.Join([][]byte{ftyp, moov(uint64(len(ftyp) + size + 8)), mkBox("mdat", payload)}, nil)
+}
+
+func writeFile(t *testing.T, data []byte) *File {
+	name := filepath.Join(t.TempDir(), "a.m4a")
+	if err := ioutil.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestQuicktimeChapters(t *testing.T) {
+	samples := []string{"Eins", "Zwei", "Drei", "Vier", "Fünf"}
+	starts := []uint32{0, 600, 1500, 2400, 3000}
+	var want []Chapter
+	for i, s := range samples {
+		want = append(want, Chapter{Start: time.Duration(starts[i]) * time.Second / 600, Title: s})
+	}
+	for _, tt := range []textTrack{
+		{name: "stco"},
+		{name: "co64", co64: true},
+		{name: "fixed size", fixed: true},
+		{name: "stsc with two entries", perLast: true},
+		{name: "mdhd version 1", mdhdV1: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := samples
+			if tt.fixed {
+				s = []string{"Eins", "Zwei", "Drei", "Vier", "Funf"}
+			}
+			c, err := writeFile(t, tt.build(s, starts, 600)).Chapters()
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := want
+			if tt.fixed {
+				w = append([]Chapter{}, want...)
+				w[4].Title = "Funf"
+			}
+			if !reflect.DeepEqual(c, w) {
+				t.Errorf("got %v, want %v", c, w)
+			}
+		})
+	}
+}
+
+func TestChaptersPreferNero(t *testing.T) {
+	data := textTrack{}.build([]string{"Text"}, []uint32{0}, 600)
+	f := writeFile(t, data)
+	f.moov.children = append(f.moov.children, &box{typ: "udta", children: []*box{{typ: "chpl", data: chpl(1, testChapters...)}}})
+	c, err := f.Chapters()
+	if err != nil || !reflect.DeepEqual(c, testChapters) {
+		t.Errorf("got %v %v, want the Nero chapters", c, err)
+	}
+	f.moov.remove("udta")
+	if c, err := f.Chapters(); err != nil || len(c) != 1 || c[0].Title != "Text" {
+		t.Errorf("got %v %v, want the text track", c, err)
+	}
+}
+
+func TestNoChapters(t *testing.T) {
+	f := writeFile(t, layout{name: "plain", moovFirst: true}.build())
+	if c, err := f.Chapters(); err != nil || c != nil {
+		t.Errorf("got %v %v", c, err)
+	}
+}
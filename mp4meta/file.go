@@ -0,0 +1,519 @@
+// Package mp4meta reads and writes the iTunes style metadata of MP4/M4A
+// files: the text atoms, the cover and the media kind in moov/udta/meta/ilst.
+//
+// Only the moov box is loaded into memory. Save rewrites the file with the
+// new moov in the same place, so a faststart file stays faststart, and
+// moves the chunk offsets of all tracks by the change in size.
+package mp4meta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Keys of the usual ilst text atoms
+const (
+	Title       = "\xa9nam"
+	Artist      = "\xa9ART"
+	Album       = "\xa9alb"
+	AlbumArtist = "aART"
+	Composer    = "\xa9wrt"
+	Narrator    = "\xa9nrt"
+	Genre       = "\xa9gen"
+	Year        = "\xa9day"
+	Comment     = "\xa9cmt"
+	Description = "desc"
+	LongDesc    = "ldes"
+	Copyright   = "cprt"
+	Publisher   = "\xa9pub"
+	Encoder     = "\xa9too"
+	SortTitle   = "sonm"
+	SortArtist  = "soar"
+	SortAlbum   = "soal"
+	SortAlbumAr = "soaa"
+	SortCompose = "soco"
+)
+
+// MediaKind is the iTunes media kind stored in stik
+type MediaKind byte
+
+// Some of the iTunes media kinds
+const (
+	Music     MediaKind = 1
+	Audiobook MediaKind = 2
+	Podcast   MediaKind = 21
+)
+
+// types of the data atoms
+const (
+	typeImplicit = 0
+	typeUTF8     = 1
+	typeJPEG     = 13
+	typePNG      = 14
+	typeInt      = 21
+)
+
+// ErrNoMoov is returned for files without a moov box
+var ErrNoMoov = errors.New("mp4: no moov box")
+
+type topBox struct {
+	typ    string
+	offset int64
+	size   int64
+}
+
+// File is the metadata of one MP4 file
+type File struct {
+	name  string
+	top   []topBox
+	moov  *box
+	moovN int // index of moov in top
+}
+
+// Open reads the box layout and the moov box of name
+func Open(name string) (*File, error) {
+	fh, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	fi, err := fh.Stat()
+	if err != nil {
+		return nil, err
+	}
+	f := &File{name: name, moovN: -1}
+	var off int64
+	hdr := make([]byte, 16)
+	for off < fi.Size() {
+		if _, err := fh.ReadAt(hdr[:8], off); err != nil {
+			return nil, fmt.Errorf("%v: %w", name, err)
+		}
+		size := int64(binary.BigEndian.Uint32(hdr))
+		typ := string(hdr[4:8])
+		switch size {
+		case 0:
+			size = fi.Size() - off
+		case 1:
+			if _, err := fh.ReadAt(hdr[8:16], off+8); err != nil {
+				return nil, fmt.Errorf("%v: %w", name, err)
+			}
+			size = int64(binary.BigEndian.Uint64(hdr[8:]))
+		}
+		if size < 8 || off+size > fi.Size() {
+			return nil, fmt.Errorf("%v: broken box %q at %d", name, typ, off)
+		}
+		if typ == "moov" {
+			buf := make([]byte, size)
+			if _, err := fh.ReadAt(buf, off); err != nil {
+				return nil, fmt.Errorf("%v: %w", name, err)
+			}
+			boxes, err := parseBoxes(buf, false)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %w", name, err)
+			}
+			f.moov = boxes[0]
+			f.moovN = len(f.top)
+		}
+		f.top = append(f.top, topBox{typ: typ, offset: off, size: size})
+		off += size
+	}
+	if f.moov == nil {
+		return nil, fmt.Errorf("%v: %w", name, ErrNoMoov)
+	}
+	return f, nil
+}
+
+// ilst returns the item list, with create it is added if missing
+func (f *File) ilst(create bool) *box {
+	if l := f.moov.path("udta", "meta", "ilst"); l != nil || !create {
+		return l
+	}
+	udta := f.moov.child("udta")
+	if udta == nil {
+		udta = &box{typ: "udta"}
+		f.moov.children = append(f.moov.children, udta)
+	}
+	meta := udta.child("meta")
+	if meta == nil {
+		// hdlr: version/flags, pre defined, handler type, reserved, name
+		hdlr := &box{typ: "hdlr", data: append(make([]byte, 8), []byte("mdirappl\x00\x00\x00\x00\x00\x00\x00\x00\x00")...)}
+		meta = &box{typ: "meta", data: make([]byte, 4), children: []*box{hdlr}}
+		udta.children = append(udta.children, meta)
+	}
+	l := &box{typ: "ilst"}
+	meta.children = append(meta.children, l)
+	return l
+}
+
+// Freeform returns the key of a freeform atom, usable with Text and
+// SetText, e.g. Freeform("com.apple.iTunes", "LANGUAGE")
+func Freeform(mean string, name string) string {
+	return "----:" + mean + ":" + name
+}
+
+// itemKey is the type of an ilst item, or the Freeform key for ----
+func itemKey(b *box) string {
+	if b.typ != "----" {
+		return b.typ
+	}
+	var mean, name string
+	if m := b.child("mean"); m != nil && len(m.data) >= 4 {
+		mean = string(m.data[4:])
+	}
+	if n := b.child("name"); n != nil && len(n.data) >= 4 {
+		name = string(n.data[4:])
+	}
+	return Freeform(mean, name)
+}
+
+// sameKey compares keys, the names of freeform atoms are not case
+// sensitive as writers do not agree on it
+func sameKey(a string, b string) bool {
+	if strings.HasPrefix(a, "----:") {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// item returns the first ilst item of key
+func (f *File) item(key string) *box {
+	l := f.ilst(false)
+	if l == nil {
+		return nil
+	}
+	for _, c := range l.children {
+		if sameKey(itemKey(c), key) {
+			return c
+		}
+	}
+	return nil
+}
+
+// data returns the payload of the first data atom of key without the
+// type and locale, and the type
+func (f *File) data(key string) ([]byte, int) {
+	item := f.item(key)
+	if item == nil {
+		return nil, 0
+	}
+	d := item.child("data")
+	if d == nil || len(d.data) < 8 {
+		return nil, 0
+	}
+	return d.data[8:], int(binary.BigEndian.Uint32(d.data) & 0xffffff)
+}
+
+func (f *File) setData(key string, typ int, payload []byte) {
+	l := f.ilst(true)
+	d := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(d, uint32(typ))
+	d = append(d, payload...)
+	item := &box{typ: key, children: []*box{{typ: "data", data: d}}}
+	if strings.HasPrefix(key, "----:") {
+		mn := strings.SplitN(key[5:], ":", 2)
+		if len(mn) != 2 {
+			return
+		}
+		item.typ = "----"
+		item.children = []*box{
+			{typ: "mean", data: append(make([]byte, 4), mn[0]...)},
+			{typ: "name", data: append(make([]byte, 4), mn[1]...)},
+			item.children[0],
+		}
+	}
+	for i, c := range l.children {
+		if sameKey(itemKey(c), key) {
+			l.children[i] = item
+			return
+		}
+	}
+	l.children = append(l.children, item)
+}
+
+// Text returns the value of a text atom, e.g. Title
+func (f *File) Text(key string) string {
+	d, typ := f.data(key)
+	if typ != typeUTF8 {
+		return ""
+	}
+	return string(d)
+}
+
+// Keys returns the keys of all atoms in the item list
+func (f *File) Keys() []string {
+	l := f.ilst(false)
+	if l == nil {
+		return nil
+	}
+	keys := make([]string, 0, len(l.children))
+	for _, c := range l.children {
+		keys = append(keys, itemKey(c))
+	}
+	return keys
+}
+
+// SetText sets a text atom, an empty value removes it
+func (f *File) SetText(key string, value string) {
+	if value == "" {
+		f.Remove(key)
+		return
+	}
+	f.setData(key, typeUTF8, []byte(value))
+}
+
+// Remove drops an atom from the item list
+func (f *File) Remove(key string) {
+	l := f.ilst(false)
+	if l == nil {
+		return
+	}
+	kept := l.children[:0]
+	for _, c := range l.children {
+		if !sameKey(itemKey(c), key) {
+			kept = append(kept, c)
+		}
+	}
+	l.children = kept
+}
+
+// Cover returns the first cover image and its extension, jpg or png
+func (f *File) Cover() ([]byte, string) {
+	d, typ := f.data("covr")
+	switch typ {
+	case typeJPEG:
+		return d, "jpg"
+	case typePNG:
+		return d, "png"
+	case typeImplicit:
+		if len(d) > 0 {
+			return d, imageExt(d)
+		}
+	}
+	return nil, ""
+}
+
+// SetCover replaces all covers by img, a JPEG or PNG image
+func (f *File) SetCover(img []byte) error {
+	switch imageExt(img) {
+	case "jpg":
+		f.setData("covr", typeJPEG, img)
+	case "png":
+		f.setData("covr", typePNG, img)
+	default:
+		return errors.New("mp4: cover is neither JPEG nor PNG")
+	}
+	return nil
+}
+
+func imageExt(img []byte) string {
+	switch {
+	case bytes.HasPrefix(img, []byte("\xff\xd8\xff")):
+		return "jpg"
+	case bytes.HasPrefix(img, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	}
+	return ""
+}
+
+// MediaKind returns the stik value, 0 if not set
+func (f *File) MediaKind() MediaKind {
+	d, _ := f.data("stik")
+	if len(d) == 0 {
+		return 0
+	}
+	return MediaKind(d[len(d)-1])
+}
+
+// SetMediaKind sets stik, e.g. to Audiobook
+func (f *File) SetMediaKind(k MediaKind) {
+	f.setData("stik", typeInt, []byte{byte(k)})
+}
+
+// Track returns the track number and the number of tracks from trkn
+func (f *File) Track() (int, int) {
+	return f.pair("trkn")
+}
+
+// SetTrack sets trkn, total 0 means unknown
+func (f *File) SetTrack(n int, total int) {
+	f.setPair("trkn", n, total, 8)
+}
+
+// Disk returns the disk number and the number of disks from disk
+func (f *File) Disk() (int, int) {
+	return f.pair("disk")
+}
+
+// SetDisk sets disk, total 0 means unknown
+func (f *File) SetDisk(n int, total int) {
+	f.setPair("disk", n, total, 6)
+}
+
+// pair reads the number and total of trkn and disk: 2 bytes padding,
+// number and total as 16 bit each
+func (f *File) pair(key string) (int, int) {
+	d, _ := f.data(key)
+	if len(d) < 6 {
+		return 0, 0
+	}
+	return int(binary.BigEndian.Uint16(d[2:])), int(binary.BigEndian.Uint16(d[4:]))
+}
+
+func (f *File) setPair(key string, n int, total int, size int) {
+	d := make([]byte, size)
+	binary.BigEndian.PutUint16(d[2:], uint16(n))
+	binary.BigEndian.PutUint16(d[4:], uint16(total))
+	f.setData(key, typeImplicit, d)
+}
+
+// LanguageKey is the freeform atom some taggers keep the language in
+var LanguageKey = Freeform("com.apple.iTunes", "LANGUAGE")
+
+// Language returns the LANGUAGE freeform atom, or else the ISO 639-2
+// code of the first audio track. It is empty if neither is set.
+func (f *File) Language() string {
+	if l := f.Text(LanguageKey); l != "" {
+		return l
+	}
+	for _, t := range f.moov.children {
+		if t.typ != "trak" {
+			continue
+		}
+		hdlr := t.path("mdia", "hdlr")
+		if hdlr == nil || len(hdlr.data) < 12 || string(hdlr.data[8:12]) != "soun" {
+			continue
+		}
+		mdhd := t.path("mdia", "mdhd")
+		if mdhd == nil || len(mdhd.data) < 4 {
+			continue
+		}
+		off := 20 // version 0: 32 bit times
+		if mdhd.data[0] == 1 {
+			off = 32
+		}
+		if len(mdhd.data) < off+2 {
+			continue
+		}
+		p := binary.BigEndian.Uint16(mdhd.data[off:])
+		code := string([]byte{byte(p>>10&0x1f) + 0x60, byte(p>>5&0x1f) + 0x60, byte(p&0x1f) + 0x60})
+		if p != 0 && code != "und" {
+			return code
+		}
+	}
+	return ""
+}
+
+// shiftChunkOffsets moves all chunk offsets at or behind from by delta
+func (f *File) shiftChunkOffsets(from int64, delta int64) error {
+	return f.moov.walk(func(b *box) error {
+		switch b.typ {
+		case "stco":
+			if len(b.data) < 8 {
+				return errShortBox
+			}
+			n := int(binary.BigEndian.Uint32(b.data[4:]))
+			if len(b.data) < 8+4*n {
+				return errShortBox
+			}
+			for i := 0; i < n; i++ {
+				p := b.data[8+4*i:]
+				o := int64(binary.BigEndian.Uint32(p))
+				if o < from {
+					continue
+				}
+				o += delta
+				if o < 0 || o > 0xffffffff {
+					return errors.New("mp4: chunk offset does not fit in stco")
+				}
+				binary.BigEndian.PutUint32(p, uint32(o))
+			}
+		case "co64":
+			if len(b.data) < 8 {
+				return errShortBox
+			}
+			n := int(binary.BigEndian.Uint32(b.data[4:]))
+			if len(b.data) < 8+8*n {
+				return errShortBox
+			}
+			for i := 0; i < n; i++ {
+				p := b.data[8+8*i:]
+				o := int64(binary.BigEndian.Uint64(p))
+				if o >= from {
+					binary.BigEndian.PutUint64(p, uint64(o+delta))
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Save writes the changed metadata back. The file is written to a temp
+// file next to it, which then replaces the original.
+func (f *File) Save() error {
+	old := f.top[f.moovN]
+	delta := int64(f.moov.size()) - old.size
+	if delta != 0 {
+		if err := f.shiftChunkOffsets(old.offset+old.size, delta); err != nil {
+			return fmt.Errorf("%v: %w", f.name, err)
+		}
+	}
+	src, err := os.Open(f.name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(f.name), ".mp4meta-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	err = f.writeTo(tmp, src)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("%v: %w", f.name, err)
+	}
+	if err := os.Chmod(tmp.Name(), fi.Mode()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), f.name); err != nil {
+		return err
+	}
+	// the layout changed, read it again for further changes
+	n, err := Open(f.name)
+	if err != nil {
+		return err
+	}
+	*f = *n
+	return nil
+}
+
+func (f *File) writeTo(w io.Writer, src io.ReaderAt) error {
+	for i, t := range f.top {
+		if i == f.moovN {
+			if err := f.moov.write(w); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := io.Copy(w, io.NewSectionReader(src, t.offset, t.size)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
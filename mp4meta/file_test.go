@@ -0,0 +1,245 @@
+package mp4meta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func mkBox(typ string, payload ...[]byte) []byte {
+	p := bytes.Join(payload, nil)
+	b := make([]byte, 8, 8+len(p))
+	binary.BigEndian.PutUint32(b, uint32(8+len(p)))
+	copy(b[4:], typ)
+	return append(b, p...)
+}
+
+// mkBox64 writes the box with a 64 bit size
+func mkBox64(typ string, payload ...[]byte) []byte {
+	p := bytes.Join(payload, nil)
+	b := make([]byte, 16, 16+len(p))
+	binary.BigEndian.PutUint32(b, 1)
+	copy(b[4:], typ)
+	binary.BigEndian.PutUint64(b[8:], uint64(16+len(p)))
+	return append(b, p...)
+}
+
+func be32(v ...uint32) []byte {
+	b := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.BigEndian.PutUint32(b[4*i:], x)
+	}
+	return b
+}
+
+func be64(v ...uint64) []byte {
+	b := make([]byte, 8*len(v))
+	for i, x := range v {
+		binary.BigEndian.PutUint64(b[8*i:], x)
+	}
+	return b
+}
+
+var chunks = [][]byte{[]byte("FIRSTCHUNK"), []byte("SECONDCHUNK")}
+
+type layout struct {
+	name      string
+	moovFirst bool   // faststart
+	mdat64    bool   // mdat with a 64 bit size
+	co64      bool   // co64 instead of stco
+	udta      []byte // content of moov/udta, nil for none
+}
+
+// build returns an mp4 file with the two chunks in mdat
+func (l layout) build() []byte {
+	ftyp := mkBox("ftyp", []byte("M4A \x00\x00\x02\x00isomiso2"))
+	payload := bytes.Join(chunks, nil)
+	mdat := func() []byte {
+		if l.mdat64 {
+			return mkBox64("mdat", payload)
+		}
+		return mkBox("mdat", payload)
+	}()
+	mdatHdr := len(mdat) - len(payload)
+	moov := func(first uint64) []byte {
+		offsets := []uint64{first, first + uint64(len(chunks[0]))}
+		var co []byte
+		if l.co64 {
+			co = mkBox("co64", be32(0, 2), be64(offsets...))
+		} else {
+			co = mkBox("stco", be32(0, 2, uint32(offsets[0]), uint32(offsets[1])))
+		}
+		stbl := mkBox("stbl", co)
+		trak := mkBox("trak", mkBox("mdia", mkBox("minf", stbl)))
+		boxes := [][]byte{mkBox("mvhd", make([]byte, 100)), trak}
+		if l.udta != nil {
+			boxes = append(boxes, mkBox("udta", l.udta))
+		}
+		return mkBox("moov", boxes...)
+	}
+	if l.moovFirst {
+		size := len(moov(0))
+		return bytes.Join([][]byte{ftyp, moov(uint64(len(ftyp) + size + mdatHdr)), mdat}, nil)
+	}
+	return bytes.Join([][]byte{ftyp, mdat, moov(uint64(len(ftyp) + mdatHdr))}, nil)
+}
+
+func ilstItem(typ string, value string) []byte {
+	return mkBox(typ, mkBox("data", be32(typeUTF8, 0), []byte(value)))
+}
+
+func hdlrMdir() []byte {
+	return mkBox("hdlr", make([]byte, 8), []byte("mdirappl"), make([]byte, 9))
+}
+
+var layouts = []layout{
+	{name: "faststart", moovFirst: true},
+	{name: "moov after mdat"},
+	{name: "64 bit mdat", mdat64: true},
+	{name: "64 bit mdat faststart", mdat64: true, moovFirst: true},
+	{name: "co64", co64: true, moovFirst: true},
+	{name: "co64 moov after mdat", co64: true},
+	{name: "meta full box", moovFirst: true,
+		udta: mkBox("meta", make([]byte, 4), hdlrMdir(), mkBox("ilst", ilstItem(Album, "Old Album")))},
+	{name: "meta quicktime", moovFirst: true,
+		udta: mkBox("meta", hdlrMdir(), mkBox("ilst", ilstItem(Album, "Old Album")))},
+	{name: "udta without meta", moovFirst: true, udta: mkBox("\xa9nam", []byte("x"))},
+	{name: "meta without ilst", moovFirst: true, udta: mkBox("meta", make([]byte, 4), hdlrMdir())},
+}
+
+// chunkOffsets returns the offsets of stco or co64 of f
+func chunkOffsets(t *testing.T, f *File) []int64 {
+	stbl := f.moov.path("trak", "mdia", "minf", "stbl")
+	var o []int64
+	if b := stbl.child("stco"); b != nil {
+		for i := 0; i < int(binary.BigEndian.Uint32(b.data[4:])); i++ {
+			o = append(o, int64(binary.BigEndian.Uint32(b.data[8+4*i:])))
+		}
+	}
+	if b := stbl.child("co64"); b != nil {
+		for i := 0; i < int(binary.BigEndian.Uint32(b.data[4:])); i++ {
+			o = append(o, int64(binary.BigEndian.Uint64(b.data[8+8*i:])))
+		}
+	}
+	if len(o) != len(chunks) {
+		t.Fatalf("got %d chunk offsets, want %d", len(o), len(chunks))
+	}
+	return o
+}
+
+func checkChunks(t *testing.T, name string) {
+	f, err := Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, o := range chunkOffsets(t, f) {
+		if o+int64(len(chunks[i])) > int64(len(data)) || !bytes.Equal(data[o:o+int64(len(chunks[i]))], chunks[i]) {
+			t.Errorf("chunk %d not at offset %d", i, o)
+		}
+	}
+}
+
+func TestSave(t *testing.T) {
+	for _, l := range layouts {
+		t.Run(l.name, func(t *testing.T) {
+			name := filepath.Join(t.TempDir(), "a.m4a")
+			if err := ioutil.WriteFile(name, l.build(), 0644); err != nil {
+				t.Fatal(err)
+			}
+			checkChunks(t, name)
+			hadAlbum := false
+			if f, err := Open(name); err != nil {
+				t.Fatal(err)
+			} else {
+				hadAlbum = f.Text(Album) != ""
+			}
+
+			// grow
+			f, err := Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.SetText(Title, "A Title")
+			f.SetMediaKind(Audiobook)
+			f.SetTrack(3, 12)
+			f.SetDisk(1, 2)
+			f.SetText(LanguageKey, "deu")
+			if err := f.SetCover(append([]byte("\xff\xd8\xff"), make([]byte, 5000)...)); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Save(); err != nil {
+				t.Fatal(err)
+			}
+			checkChunks(t, name)
+			g, err := Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v := g.Text(Title); v != "A Title" {
+				t.Errorf("title %q", v)
+			}
+			if k := g.MediaKind(); k != Audiobook {
+				t.Errorf("media kind %v", k)
+			}
+			if n, m := g.Track(); n != 3 || m != 12 {
+				t.Errorf("track %d/%d", n, m)
+			}
+			if n, m := g.Disk(); n != 1 || m != 2 {
+				t.Errorf("disk %d/%d", n, m)
+			}
+			if v := g.Language(); v != "deu" {
+				t.Errorf("language %q", v)
+			}
+			if c, ext := g.Cover(); ext != "jpg" || len(c) != 5003 {
+				t.Errorf("cover %v %d bytes", ext, len(c))
+			}
+			if hadAlbum && g.Text(Album) != "Old Album" {
+				t.Errorf("album lost: %q", g.Text(Album))
+			}
+
+			// shrink
+			g.Remove("covr")
+			g.SetText(Title, "")
+			if err := g.Save(); err != nil {
+				t.Fatal(err)
+			}
+			checkChunks(t, name)
+			h, err := Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c, _ := h.Cover(); c != nil || h.Text(Title) != "" {
+				t.Errorf("cover or title not removed")
+			}
+			if h.MediaKind() != Audiobook {
+				t.Errorf("media kind lost")
+			}
+		})
+	}
+}
+
+func TestOpenBroken(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"no moov":        mkBox("ftyp", []byte("M4A ")),
+		"box past end":   append(be32(100), []byte("moov")...),
+		"short child":    mkBox("moov", be32(100), []byte("trak")),
+		"short 64 bit":   append(be32(1), []byte("mdat")...),
+		"size below hdr": append(be32(4), []byte("moov")...),
+	} {
+		t.Run(name, func(t *testing.T) {
+			f := filepath.Join(t.TempDir(), "a.m4a")
+			if err := ioutil.WriteFile(f, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Open(f); err == nil {
+				t.Errorf("no error")
+			}
+		})
+	}
+}
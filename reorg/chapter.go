@@ -0,0 +1,96 @@
+package reorg
+
+import (
+	"bytes"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ChapterData is what a Config.ChapterTemplate can use
+type ChapterData struct {
+	Title       string // the title tag of the track
+	Index       int    // position in the book, starting with 1
+	Disk        int
+	TrackOnDisk int
+	Part        int    // target part, starting with 1
+	Filename    string // base name of the source file
+	Label       string // the chapter label with a blank, e.g. "Chapter "
+}
+
+// numberedChapters is the default template if the titles are no use
+const numberedChapters = "{{.Label}}{{.Index}}"
+
+// titledChapters is the default template if all tracks have a title of
+// their own
+const titledChapters = "{{.Title}}"
+
+// parseChapterTemplate compiles the chapter template and tries it on
+// some sample data, so a template using unknown fields fails early
+func parseChapterTemplate(text string) (*template.Template, error) {
+	t, err := template.New("chapter").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	sample := ChapterData{Title: "Title", Index: 1, Disk: 1, TrackOnDisk: 1, Part: 1, Filename: "01.m4a", Label: "Chapter "}
+	if err := t.Execute(&bytes.Buffer{}, sample); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// chapterTitler returns the function naming the chapters of b. Without
+// a template the track titles are used if they are all set and
+// distinct, otherwise the chapters are numbered.
+func chapterTitler(b *Book, cfg *Config) func(ChapterData) string {
+	text := cfg.ChapterTemplate
+	if text == "" {
+		text = numberedChapters
+		if distinctTitles(b.Tracks) {
+			text = titledChapters
+		}
+	}
+	t, err := parseChapterTemplate(text)
+	if err != nil {
+		log.Errorf("chapter template %q: %v, numbering the chapters", text, err)
+		t = template.Must(parseChapterTemplate(numberedChapters))
+	}
+	return func(d ChapterData) string {
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, d); err != nil {
+			log.Errorf("chapter template: %v", err)
+			return d.Label + strconv.Itoa(d.Index)
+		}
+		return buf.String()
+	}
+}
+
+// distinctTitles tells if all tracks have a title and no two the same
+func distinctTitles(tracks []Track) bool {
+	seen := map[string]bool{}
+	for _, t := range tracks {
+		title := strings.TrimSpace(t.Title)
+		if title == "" || seen[title] {
+			return false
+		}
+		seen[title] = true
+	}
+	return len(tracks) > 0
+}
+
+// chapterData fills the template data of track t of a plan
+func chapterData(p *Plan, t int, part int) ChapterData {
+	track := p.Book.Tracks[t]
+	return ChapterData{
+		Title:       strings.TrimSpace(track.Title),
+		Index:       t + 1,
+		Disk:        track.DiskNo,
+		TrackOnDisk: track.TrackNo,
+		Part:        part,
+		Filename:    filepath.Base(track.Filename),
+		Label:       p.Labels.Chapter + " ",
+	}
+}
@@ -0,0 +1,248 @@
+package reorg
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// EnvPrefix is the prefix of the environment variables read by ApplyEnv
+const EnvPrefix = "M4AREORG_"
+
+// Config holds all tunables of the scan, plan and join steps.
+// The toml tags are the keys in the config file, the environment
+// variables are EnvPrefix followed by the key in upper case.
+type Config struct {
+	// AlreadyLongEnough : if a track is that long in ms do not process
+	AlreadyLongEnough int `toml:"already_long_enough"`
+	// MaxDuration : max length of target track in ms
+	MaxDuration int `toml:"max_duration"`
+	// TmpDir : root of the per book temp dirs for ffmpeg
+	TmpDir string `toml:"tmp_dir"`
+	// KeepTemp : do not remove the per book temp dirs, for debugging
+	KeepTemp bool `toml:"keep_temp"`
+	// ToolBinPath : where to find ffmpeg
+	ToolBinPath string `toml:"tool_bin_path"`
+	// ChapterTitle : title of chapter in chapter list, empty uses the
+	// chapter label of the language
+	ChapterTitle string `toml:"chapter_title"`
+	// Lang : language of the part and chapter labels, auto takes it
+	// from the language tag of each book
+	Lang string `toml:"lang"`
+	// Labels : label sets by language, added to DefaultLabels
+	Labels map[string]Labels `toml:"labels"`
+	// ChapterTemplate : text/template for the chapter titles, see
+	// ChapterData. Empty uses the track titles if they are distinct and
+	// ChapterTitle with the number otherwise.
+	ChapterTemplate string `toml:"chapter_template"`
+	// TargetDir : the path for processed files
+	TargetDir string `toml:"target_dir"`
+	// SourceChapters : what to do with chapters in the source files,
+	// prefix, flatten or ignore
+	SourceChapters string `toml:"source_chapters"`
+	// Strict : use the tags only, do not infer missing numbers, author
+	// or book from the file and folder names
+	Strict bool `toml:"strict"`
+	// PathPatterns : where author and book are taken from if the tags
+	// are empty, see PathPattern
+	PathPatterns []string `toml:"path_patterns"`
+	// DeepCheck : decode all tracks before joining and compare the
+	// decoded length with the one of the container
+	DeepCheck bool `toml:"deep_check"`
+	// DurationTolerance : allowed difference of the lengths in ms
+	DurationTolerance int `toml:"duration_tolerance"`
+	// FormatPolicy : books with tracks of different codec, sample rate,
+	// channels or profile are rejected, or the odd tracks are re-encoded
+	// to the format of most tracks: reject or normalize
+	FormatPolicy string `toml:"format_policy"`
+	// TrackPolicy : what the track checks let pass, strict or any of
+	// allow-gaps, allow-extras and ignore-maxtrack
+	TrackPolicy []string `toml:"track_policy"`
+	// GroupBy : tags the author is taken from, the first one set wins
+	GroupBy []string `toml:"group_by"`
+	// NarratorTag : where the narrator goes in the output, composer,
+	// narrator or none
+	NarratorTag string `toml:"narrator_tag"`
+}
+
+// DefaultConfig returns the built in defaults
+func DefaultConfig() *Config {
+	return &Config{
+		AlreadyLongEnough: 3600000,
+		MaxDuration:       23400000,
+		TmpDir:            "./tmp/",
+		ToolBinPath:       "/usr/local/bin",
+		Lang:              "auto",
+		TargetDir:         "./target",
+		PathPatterns:      []string{"{author}/{book}", "{author} - {book}"},
+		DurationTolerance: 2000,
+		FormatPolicy:      "reject",
+		TrackPolicy:       []string{PolicyStrict},
+		GroupBy:           []string{"artist"},
+		NarratorTag:       "composer",
+		SourceChapters:    "prefix",
+	}
+}
+
+// Validate checks the values which must be one of a list or in a range
+func (c *Config) Validate() error {
+	if c.MaxDuration <= 0 {
+		return fmt.Errorf("max_duration: %d is not positive", c.MaxDuration)
+	}
+	if c.AlreadyLongEnough < 0 {
+		return fmt.Errorf("already_long_enough: %d is negative", c.AlreadyLongEnough)
+	}
+	if c.DurationTolerance < 0 {
+		return fmt.Errorf("duration_tolerance: %d is negative", c.DurationTolerance)
+	}
+	if len(c.GroupBy) == 0 {
+		return fmt.Errorf("group_by: empty, use some of %v", GroupByKeys)
+	}
+	for _, g := range c.GroupBy {
+		if !contains(GroupByKeys, g) {
+			return fmt.Errorf("group_by: %q is not one of %v", g, GroupByKeys)
+		}
+	}
+	if !contains(NarratorTags, c.NarratorTag) {
+		return fmt.Errorf("narrator_tag: %q is not one of %v", c.NarratorTag, NarratorTags)
+	}
+	if _, err := c.pathPatterns(); err != nil {
+		return err
+	}
+	for _, p := range c.TrackPolicy {
+		if !contains(TrackPolicies, p) {
+			return fmt.Errorf("track_policy: %q is not one of %v", p, TrackPolicies)
+		}
+		if p == PolicyStrict && len(c.TrackPolicy) > 1 {
+			return fmt.Errorf("track_policy: %v does not go with %v", PolicyStrict, c.TrackPolicy)
+		}
+	}
+	if !contains(FormatPolicies, c.FormatPolicy) {
+		return fmt.Errorf("format_policy: %q is not one of %v", c.FormatPolicy, FormatPolicies)
+	}
+	if !contains(SourceChapterPolicies, c.SourceChapters) {
+		return fmt.Errorf("source_chapters: %q is not one of %v", c.SourceChapters, SourceChapterPolicies)
+	}
+	if c.Lang != "auto" && !c.knownLang(normalizeLang(c.Lang)) {
+		return fmt.Errorf("lang: no labels for %q, add them to the config file", c.Lang)
+	}
+	if c.ChapterTemplate != "" {
+		if _, err := parseChapterTemplate(c.ChapterTemplate); err != nil {
+			return fmt.Errorf("chapter_template: %w", err)
+		}
+	}
+	return nil
+}
+
+// pathPatterns compiles the PathPatterns
+func (c *Config) pathPatterns() ([]*PathPattern, error) {
+	l := make([]*PathPattern, 0, len(c.PathPatterns))
+	for _, s := range c.PathPatterns {
+		p, err := CompilePathPattern(s)
+		if err != nil {
+			return nil, fmt.Errorf("path_patterns: %w", err)
+		}
+		l = append(l, p)
+	}
+	return l, nil
+}
+
+// NarratorTags are the allowed values of Config.NarratorTag
+var NarratorTags = []string{"composer", "narrator", "none"}
+
+// SourceChapterPolicies are the allowed values of Config.SourceChapters:
+// prefix names the chapters "track chapter - source chapter", flatten
+// uses the source chapter titles as they are, ignore makes one chapter
+// per track
+var SourceChapterPolicies = []string{"prefix", "flatten", "ignore"}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultConfigFile is where the config file is looked for if none is
+// given, e.g. ~/.config/m4areorg/config.toml
+func DefaultConfigFile() string {
+	d, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(d, "m4areorg", "config.toml")
+}
+
+// LoadFile reads a TOML config file on top of c. Keys missing in the
+// file keep their value, unknown keys are an error.
+func (c *Config) LoadFile(name string) error {
+	md, err := toml.DecodeFile(name, c)
+	if err != nil {
+		return err
+	}
+	if u := md.Undecoded(); len(u) > 0 {
+		return fmt.Errorf("%v: unknown keys %v", name, u)
+	}
+	return nil
+}
+
+// ApplyEnv sets all values given as M4AREORG_<KEY> in the environment
+func (c *Config) ApplyEnv() error {
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		key := v.Type().Field(i).Tag.Get("toml")
+		if key == "" || key == "-" {
+			continue
+		}
+		env := EnvPrefix + strings.ToUpper(key)
+		val, ok := os.LookupEnv(env)
+		if !ok {
+			continue
+		}
+		f := v.Field(i)
+		switch f.Kind() {
+		case reflect.String:
+			f.SetString(val)
+		case reflect.Int:
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				return fmt.Errorf("%v: %w", env, err)
+			}
+			f.SetInt(int64(n))
+		case reflect.Slice:
+			f.Set(reflect.ValueOf(SplitList(val)))
+		case reflect.Bool:
+			b, err := strconv.ParseBool(val)
+			if err != nil {
+				return fmt.Errorf("%v: %w", env, err)
+			}
+			f.SetBool(b)
+		}
+	}
+	return nil
+}
+
+// SplitList splits a comma separated list, as used for list values in
+// the environment and on the command line
+func SplitList(s string) []string {
+	l := []string{}
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			l = append(l, e)
+		}
+	}
+	return l
+}
+
+// Write prints the config in the config file format
+func (c *Config) Write(w io.Writer) error {
+	return toml.NewEncoder(w).Encode(c)
+}
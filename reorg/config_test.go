@@ -0,0 +1,26 @@
+package reorg
+
+import "testing"
+
+func TestValidate(t *testing.T) {
+	if err := DefaultConfig().Validate(); err != nil {
+		t.Fatalf("defaults: %v", err)
+	}
+	for name, change := range map[string]func(*Config){
+		"max duration 0":       func(c *Config) { c.MaxDuration = 0 },
+		"negative max":         func(c *Config) { c.MaxDuration = -1 },
+		"negative long enough": func(c *Config) { c.AlreadyLongEnough = -1 },
+		"negative tolerance":   func(c *Config) { c.DurationTolerance = -1 },
+		"no group by":          func(c *Config) { c.GroupBy = nil },
+		"unknown group by":     func(c *Config) { c.GroupBy = []string{"album"} },
+		"strict and gaps":      func(c *Config) { c.TrackPolicy = []string{PolicyStrict, PolicyAllowGaps} },
+		"format policy":        func(c *Config) { c.FormatPolicy = "keep" },
+		"path pattern":         func(c *Config) { c.PathPatterns = []string{"{title}"} },
+	} {
+		c := DefaultConfig()
+		change(c)
+		if err := c.Validate(); err == nil {
+			t.Errorf("%v: no error", name)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package reorg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Cover is the cover image of a book. Only where it was found is kept,
+// the image is read again by Data when the book is tagged.
+type Cover struct {
+	Ext     string // jpg or png
+	Source  string // the track or image file it was taken from
+	inTrack bool   // Source is a track, not an image file
+}
+
+// Data reads the image from Source
+func (c *Cover) Data() ([]byte, error) {
+	if !c.inTrack {
+		return ioutil.ReadFile(c.Source)
+	}
+	m, err := readMetaData(c.Source)
+	if err != nil {
+		return nil, err
+	}
+	if p := m.Picture(); p != nil && len(p.Data) > 0 {
+		return p.Data, nil
+	}
+	return nil, fmt.Errorf("%v has no cover any more", c.Source)
+}
+
+// coverFiles are looked for in the folders of the tracks, if none of the
+// tracks has a cover
+var coverFiles = []string{"cover.jpg", "folder.jpg", "cover.png", "folder.png"}
+
+// findCover takes the first cover of the tracks in order, then the first
+// cover file found in their folders. It returns nil if there is none.
+func findCover(tracks []Track) *Cover {
+	for _, t := range tracks {
+		m, err := readMetaData(t.Filename)
+		if err != nil {
+			continue
+		}
+		if p := m.Picture(); p != nil && len(p.Data) > 0 {
+			return &Cover{Ext: p.Ext, Source: t.Filename, inTrack: true}
+		}
+	}
+	seen := map[string]bool{}
+	for _, t := range tracks {
+		dir := filepath.Dir(t.Filename)
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		for _, c := range coverFiles {
+			name := filepath.Join(dir, c)
+			if fi, err := os.Stat(name); err == nil && fi.Mode().IsRegular() && fi.Size() > 0 {
+				return &Cover{Ext: filepath.Ext(c)[1:], Source: name}
+			}
+		}
+	}
+	return nil
+}
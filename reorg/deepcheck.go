@@ -0,0 +1,73 @@
+package reorg
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Finding is a problem of one source file found by DeepCheck
+type Finding struct {
+	File    string `json:"file"`
+	Problem string `json:"problem"`
+}
+
+// DeepCheck decodes every track of the books which passed the checks so
+// far and compares the decoded length with the one of the container.
+// Books with findings are rejected in v. It stops early when ctx is
+// done or the decoder cannot run.
+func (l Library) DeepCheck(ctx context.Context, tools Tools, cfg *Config, v Verdicts) error {
+	for _, auth := range l.Authors() {
+		for _, book := range l.Books(auth) {
+			if !v.Get(auth, book).OK() {
+				continue
+			}
+			findings, err := deepCheckBook(ctx, tools, l[auth][book], cfg)
+			if err != nil {
+				return err
+			}
+			if len(findings) == 0 {
+				continue
+			}
+			r := reject(ReasonCorruptFile, "%d of %d files failed the decode check", len(findings), len(l[auth][book]))
+			r.Findings = findings
+			v[auth][book] = r
+			log.Errorf("%v: %v: %v", auth, book, r.Detail)
+		}
+	}
+	return nil
+}
+
+// findingsOf returns the problems found in file
+func (v Verdict) findingsOf(file string) []string {
+	var l []string
+	for _, f := range v.Findings {
+		if f.File == file {
+			l = append(l, f.Problem)
+		}
+	}
+	return l
+}
+
+func deepCheckBook(ctx context.Context, tools Tools, b Tracks, cfg *Config) ([]Finding, error) {
+	var findings []Finding
+	for _, name := range sortedKeys(b) {
+		t := b[name]
+		d, err := tools.Decode(ctx, t.Filename)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode %v: %w", t.Filename, err)
+		}
+		if d.Errors != "" {
+			log.Warnf("[Decode] %v: %v", t.Filename, d.Errors)
+			findings = append(findings, Finding{File: t.Filename, Problem: "decode errors: " + d.Errors})
+		}
+		diff := d.Duration - int(t.PlayLength)
+		if d.Duration > 0 && (diff > cfg.DurationTolerance || -diff > cfg.DurationTolerance) {
+			log.Warnf("[Decode] %v: decoded %d ms, container %d ms", t.Filename, d.Duration, int(t.PlayLength))
+			findings = append(findings, Finding{File: t.Filename,
+				Problem: fmt.Sprintf("decoded %d ms, the container says %d ms", d.Duration, int(t.PlayLength))})
+		}
+	}
+	return findings, nil
+}
@@ -0,0 +1,46 @@
+package reorg
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+)
+
+func deepCheckLibrary() (Library, Verdicts) {
+	lib := Library{}
+	for _, t := range testBook(2, 1000).Tracks {
+		lib.Add(t)
+	}
+	return lib, Verdicts{"Doe, John": {"The Book": Verdict{}}}
+}
+
+func TestDeepCheckFindings(t *testing.T) {
+	lib, v := deepCheckLibrary()
+	fake := &FakeTools{Decoded: map[string]Decoded{
+		"/src/a.m4a": {Duration: 1000},
+		"/src/b.m4a": {Duration: 9000, Errors: "invalid data"},
+	}}
+	if err := lib.DeepCheck(context.Background(), fake, DefaultConfig(), v); err != nil {
+		t.Fatal(err)
+	}
+	r := v.Get("Doe, John", "The Book")
+	if r.Reason != ReasonCorruptFile || len(r.findingsOf("/src/b.m4a")) != 2 || len(r.findingsOf("/src/a.m4a")) != 0 {
+		t.Errorf("verdict %+v", r)
+	}
+}
+
+func TestDeepCheckCannotDecode(t *testing.T) {
+	lib, v := deepCheckLibrary()
+	fake := &FakeTools{Fail: map[string]error{"Decode": exec.ErrNotFound}}
+	err := lib.DeepCheck(context.Background(), fake, DefaultConfig(), v)
+	if !errors.Is(err, exec.ErrNotFound) {
+		t.Errorf("err %v, want %v", err, exec.ErrNotFound)
+	}
+	if r := v.Get("Doe, John", "The Book"); !r.OK() {
+		t.Errorf("book rejected: %+v", r)
+	}
+	if _, err := NewExecTools(t.TempDir()).Decode(context.Background(), "/src/a.m4a"); err == nil {
+		t.Errorf("no error without ffmpeg")
+	}
+}
@@ -0,0 +1,95 @@
+package reorg
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// ToolCall is one recorded call of FakeTools
+type ToolCall struct {
+	Op   string   // Concat, Tag, Convert or Decode
+	Args []string // the arguments as given
+	// Inputs holds the content of the text files handed to the tool,
+	// keyed by file name, read at the time of the call
+	Inputs map[string]string
+}
+
+// FakeTools records all calls instead of running anything. Concat
+// creates an empty target file, so later steps find it.
+// It is safe for concurrent use.
+type FakeTools struct {
+	// Fail makes the named operation return the error
+	Fail map[string]error
+	// Decoded is what Decode returns by file name, nothing found if
+	// the file is missing
+	Decoded map[string]Decoded
+
+	mu    sync.Mutex
+	calls []ToolCall
+}
+
+// Calls returns a copy of the recorded calls
+func (f *FakeTools) Calls() []ToolCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]ToolCall(nil), f.calls...)
+}
+
+func (f *FakeTools) record(op string, inputs []string, args ...string) error {
+	c := ToolCall{Op: op, Args: args, Inputs: map[string]string{}}
+	for _, i := range inputs {
+		data, err := ioutil.ReadFile(i)
+		if err != nil {
+			return err
+		}
+		c.Inputs[i] = string(data)
+	}
+	f.mu.Lock()
+	f.calls = append(f.calls, c)
+	f.mu.Unlock()
+	return f.Fail[op]
+}
+
+func touch(name string) error {
+	fo, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	return fo.Close()
+}
+
+// Concat records the concat list and the metadata and creates target
+func (f *FakeTools) Concat(ctx context.Context, list string, metadata string, target string) error {
+	if err := f.record("Concat", []string{list, metadata}, list, metadata, target); err != nil {
+		return err
+	}
+	return touch(target)
+}
+
+// Tag records the call, the TagSet goes to Args as key=value
+func (f *FakeTools) Tag(ctx context.Context, target string, tags TagSet) error {
+	args := []string{target, "cover=" + strconv.Itoa(len(tags.Cover)) + " bytes", "mediakind=" + strconv.Itoa(int(tags.MediaKind))}
+	for _, k := range sortedKeys(tags.Text) {
+		args = append(args, k+"="+tags.Text[k])
+	}
+	return f.record("Tag", nil, args...)
+}
+
+// Decode records the call and returns Decoded[file]
+func (f *FakeTools) Decode(ctx context.Context, file string) (Decoded, error) {
+	if err := f.record("Decode", nil, file); err != nil {
+		return Decoded{}, err
+	}
+	return f.Decoded[file], nil
+}
+
+// Convert records the call and creates target
+func (f *FakeTools) Convert(ctx context.Context, src string, target string, format AudioFormat) error {
+	if err := f.record("Convert", nil, src, target, format.String()); err != nil {
+		return err
+	}
+	return touch(target)
+}
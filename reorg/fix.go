@@ -0,0 +1,206 @@
+package reorg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/heinrichgrt/m4areorg/mp4meta"
+	log "github.com/sirupsen/logrus"
+)
+
+// Numbers are the disk and track numbers of a track
+type Numbers struct {
+	DiskNo   int `json:"disk"`
+	MaxDisk  int `json:"maxdisk"`
+	TrackNo  int `json:"track"`
+	MaxTrack int `json:"maxtrack"`
+}
+
+func numbersOf(t Track) Numbers {
+	return Numbers{DiskNo: t.DiskNo, MaxDisk: t.MaxDisk, TrackNo: t.TrackNo, MaxTrack: t.MaxTrack}
+}
+
+func (n Numbers) apply(t *Track) {
+	t.DiskNo, t.MaxDisk, t.TrackNo, t.MaxTrack = n.DiskNo, n.MaxDisk, n.TrackNo, n.MaxTrack
+}
+
+func (n Numbers) String() string {
+	return fmt.Sprintf("disk %d/%d track %d/%d", n.DiskNo, n.MaxDisk, n.TrackNo, n.MaxTrack)
+}
+
+// TrackFix is a proposed change of the numbers of one track
+type TrackFix struct {
+	Filename string
+	Old      Numbers
+	New      Numbers
+}
+
+func (f TrackFix) String() string {
+	return fmt.Sprintf("%s: %v -> %v", f.Filename, f.Old, f.New)
+}
+
+// ProposeFix returns the changes which repair the numbers of a book:
+// the max disk all tracks agree on, the max track of each disk set to the
+// number of tracks on it, and the tracks of a disk which are not numbered
+// 1 to n renumbered. Gaps keep the order of the track numbers, track 0
+// and duplicates renumber in filename order. A book without disk
+// numbers becomes disk 1 of 1, otherwise tracks without a disk number
+// are left alone. Old is what the tags say, before overrides and
+// inferred numbers, or with sidecar what the OverrideFile says. The
+// fixes are in filename order, nil if there is nothing to fix.
+func ProposeFix(tracks Tracks, sidecar bool) []TrackFix {
+	fixed := map[string]Numbers{}
+	undisked := withoutDisks(tracks)
+	for name, t := range tracks {
+		n := numbersOf(t)
+		if undisked {
+			n.DiskNo, n.MaxDisk = 1, 1
+		}
+		fixed[name] = n
+	}
+	maxdisk := mostCommonInt(tracks, func(t Track) int { return t.MaxDisk })
+	for _, n := range fixed {
+		if n.DiskNo > maxdisk {
+			maxdisk = n.DiskNo
+		}
+	}
+	disks := map[int][]string{}
+	for name, n := range fixed {
+		if n.DiskNo == 0 {
+			continue
+		}
+		n.MaxDisk = maxdisk
+		fixed[name] = n
+		disks[n.DiskNo] = append(disks[n.DiskNo], name)
+	}
+	for _, names := range disks {
+		sort.Slice(names, func(i, j int) bool { return naturalLess(names[i], names[j]) })
+		byName := false
+		seen := map[int]bool{}
+		for _, name := range names {
+			no := fixed[name].TrackNo
+			if no == 0 || seen[no] {
+				byName = true
+			}
+			seen[no] = true
+		}
+		if !byName {
+			sort.SliceStable(names, func(i, j int) bool { return fixed[names[i]].TrackNo < fixed[names[j]].TrackNo })
+		}
+		for i, name := range names {
+			n := fixed[name]
+			n.TrackNo = i + 1
+			n.MaxTrack = len(names)
+			fixed[name] = n
+		}
+	}
+	var fixes []TrackFix
+	over := overrides{}
+	for _, name := range sortedKeys(tracks) {
+		old := tracks[name].Tagged
+		if sidecar {
+			if n, ok := over.get(name); ok {
+				old = n
+			}
+		}
+		if old != fixed[name] {
+			fixes = append(fixes, TrackFix{Filename: name, Old: old, New: fixed[name]})
+		}
+	}
+	return fixes
+}
+
+// mostCommonInt returns the most common value of field which is not 0,
+// on a tie the smaller one
+func mostCommonInt(tracks Tracks, field func(Track) int) int {
+	count := map[int]int{}
+	for _, t := range tracks {
+		if v := field(t); v != 0 {
+			count[v]++
+		}
+	}
+	best := 0
+	for v, c := range count {
+		if c > count[best] || (c == count[best] && v < best) {
+			best = v
+		}
+	}
+	return best
+}
+
+// ApplyFix writes the fixed numbers into the tags of the source file, or
+// with sidecar into the OverrideFile in its folder
+func ApplyFix(f TrackFix, sidecar bool) error {
+	if sidecar {
+		return writeOverride(f.Filename, f.New)
+	}
+	m, err := mp4meta.Open(f.Filename)
+	if err != nil {
+		return err
+	}
+	m.SetDisk(f.New.DiskNo, f.New.MaxDisk)
+	m.SetTrack(f.New.TrackNo, f.New.MaxTrack)
+	return m.Save()
+}
+
+// OverrideFile holds the numbers of the tracks in a folder which are used
+// instead of the tags, keyed by file name
+const OverrideFile = ".m4areorg-override.json"
+
+func readOverrides(dir string) (map[string]Numbers, error) {
+	o := map[string]Numbers{}
+	data, err := ioutil.ReadFile(filepath.Join(dir, OverrideFile))
+	if os.IsNotExist(err) {
+		return o, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &o); err != nil {
+		return nil, fmt.Errorf("%v: %w", filepath.Join(dir, OverrideFile), err)
+	}
+	return o, nil
+}
+
+func writeOverride(filename string, n Numbers) error {
+	dir := filepath.Dir(filename)
+	o, err := readOverrides(dir)
+	if err != nil {
+		return err
+	}
+	o[filepath.Base(filename)] = n
+	data, err := json.MarshalIndent(o, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, OverrideFile), data, 0644)
+}
+
+// overrides caches the override files of the folders during a scan
+type overrides map[string]map[string]Numbers
+
+// get returns the override for filename, if any
+func (o overrides) get(filename string) (Numbers, bool) {
+	dir := filepath.Dir(filename)
+	if _, ok := o[dir]; !ok {
+		m, err := readOverrides(dir)
+		if err != nil {
+			log.Warnf("ignoring overrides: %v", err)
+		}
+		o[dir] = m
+	}
+	n, ok := o[dir][filepath.Base(filename)]
+	return n, ok
+}
+
+// apply replaces the numbers of t by the override for it, if any
+func (o overrides) apply(t *Track) {
+	if n, ok := o.get(t.Filename); ok {
+		log.Debugf("%v: numbers from %v: %v", t.Filename, OverrideFile, n)
+		n.apply(t)
+	}
+}
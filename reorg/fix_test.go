@@ -0,0 +1,87 @@
+package reorg
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// tagged returns tracks named 1.m4a, 2.m4a... with the numbers n as tags
+func tagged(n ...Numbers) Tracks {
+	tracks := Tracks{}
+	for i, no := range n {
+		name := fmt.Sprintf("/src/%d.m4a", i+1)
+		t := Track{Filename: name, Tagged: no}
+		no.apply(&t)
+		tracks[name] = t
+	}
+	return tracks
+}
+
+func TestProposeFix(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		in   []Numbers
+		want []Numbers // the new numbers of all tracks
+	}{
+		{"complete",
+			[]Numbers{{1, 1, 1, 2}, {1, 1, 2, 2}},
+			nil},
+		{"gap",
+			[]Numbers{{1, 1, 1, 3}, {1, 1, 2, 3}, {1, 1, 4, 3}},
+			[]Numbers{{1, 1, 1, 3}, {1, 1, 2, 3}, {1, 1, 3, 3}}},
+		{"gap keeps track order",
+			[]Numbers{{1, 1, 5, 0}, {1, 1, 2, 0}, {1, 1, 3, 0}},
+			[]Numbers{{1, 1, 3, 3}, {1, 1, 1, 3}, {1, 1, 2, 3}}},
+		{"duplicate in file name order",
+			[]Numbers{{1, 1, 2, 2}, {1, 1, 2, 2}},
+			[]Numbers{{1, 1, 1, 2}, {1, 1, 2, 2}}},
+		{"track 0",
+			[]Numbers{{1, 1, 0, 0}, {1, 1, 1, 0}},
+			[]Numbers{{1, 1, 1, 2}, {1, 1, 2, 2}}},
+		{"max disk and max track",
+			[]Numbers{{1, 0, 1, 0}, {2, 2, 1, 3}},
+			[]Numbers{{1, 2, 1, 1}, {2, 2, 1, 1}}},
+		{"no disk becomes disk 1",
+			[]Numbers{{0, 0, 1, 0}, {0, 0, 1, 0}, {0, 0, 3, 0}},
+			[]Numbers{{1, 1, 1, 3}, {1, 1, 2, 3}, {1, 1, 3, 3}}},
+		{"no disk, max disk 1",
+			[]Numbers{{0, 1, 1, 2}, {0, 1, 2, 2}},
+			[]Numbers{{1, 1, 1, 2}, {1, 1, 2, 2}}},
+		{"some without disk left alone",
+			[]Numbers{{1, 2, 1, 1}, {2, 2, 1, 1}, {0, 0, 3, 0}},
+			[]Numbers{{1, 2, 1, 1}, {2, 2, 1, 1}, {0, 0, 3, 0}}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			tracks := tagged(c.in...)
+			var got []Numbers
+			for _, f := range ProposeFix(tracks, false) {
+				if f.Old != tracks[f.Filename].Tagged {
+					t.Errorf("%v: old %v, want the tags %v", f.Filename, f.Old, tracks[f.Filename].Tagged)
+				}
+				got = append(got, f.New)
+			}
+			var want []Numbers
+			for i, n := range c.want {
+				if n != c.in[i] {
+					want = append(want, n)
+				}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestProposeFixShowsTags(t *testing.T) {
+	tracks := tagged(Numbers{1, 1, 0, 0}, Numbers{1, 1, 0, 0})
+	for name, tr := range tracks {
+		tr.TrackNo = 2 // inferred or from an override
+		tracks[name] = tr
+	}
+	fixes := ProposeFix(tracks, false)
+	if len(fixes) != 2 || fixes[0].Old != (Numbers{1, 1, 0, 0}) || fixes[1].New != (Numbers{1, 1, 2, 2}) {
+		t.Errorf("fixes %v", fixes)
+	}
+}
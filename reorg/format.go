@@ -0,0 +1,146 @@
+package reorg
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dwbuiten/go-mediainfo/mediainfo"
+)
+
+// AudioFormat are the stream parameters which must be the same for all
+// tracks joined without re-encoding
+type AudioFormat struct {
+	Codec      string // as mediainfo names it, e.g. AAC
+	Profile    string // e.g. LC
+	SampleRate int
+	Channels   int
+}
+
+func (f AudioFormat) String() string {
+	s := f.Codec
+	if f.Profile != "" {
+		s += " " + f.Profile
+	}
+	return fmt.Sprintf("%s %d Hz %d ch", s, f.SampleRate, f.Channels)
+}
+
+// Known tells if the format could be read at all
+func (f AudioFormat) Known() bool {
+	return f.Codec != ""
+}
+
+// readFormat reads the format of the first audio stream, what mediainfo
+// does not know stays empty
+func readFormat(info *mediainfo.File) AudioFormat {
+	get := func(keys ...string) string {
+		for _, k := range keys {
+			if v, err := info.Get(k, 0, mediainfo.Audio); err == nil && v != "" {
+				return strings.TrimSpace(v)
+			}
+		}
+		return ""
+	}
+	f := AudioFormat{
+		Codec:   get("Format"),
+		Profile: get("Format_AdditionalFeatures", "Format_Profile"),
+	}
+	f.SampleRate, _ = strconv.Atoi(get("SamplingRate"))
+	f.Channels, _ = strconv.Atoi(get("Channel(s)"))
+	return f
+}
+
+// FormatPolicies are the allowed values of Config.FormatPolicy: reject
+// books with tracks of different formats or normalize the odd ones to
+// the format of most tracks
+var FormatPolicies = []string{"reject", "normalize"}
+
+// majorityFormat returns the format of most tracks, on a tie the one of
+// the earlier track. Tracks of unknown format do not count.
+func majorityFormat(tracks []Track) AudioFormat {
+	count := map[AudioFormat]int{}
+	var best AudioFormat
+	for _, t := range tracks {
+		if !t.Format.Known() {
+			continue
+		}
+		count[t.Format]++
+		if count[t.Format] > count[best] {
+			best = t.Format
+		}
+	}
+	return best
+}
+
+// oddTracks returns the filenames of the tracks not in format f
+func oddTracks(tracks []Track, f AudioFormat) []string {
+	var odd []string
+	for _, t := range tracks {
+		if t.Format.Known() && t.Format != f {
+			odd = append(odd, t.Filename)
+		}
+	}
+	sort.Strings(odd)
+	return odd
+}
+
+// OddTracks returns the tracks of b which are not in the format of the
+// book, they are re-encoded with the normalize policy
+func OddTracks(b *Book) []string {
+	return oddTracks(b.Tracks, b.Format)
+}
+
+// checkFormat rejects books with tracks of different formats unless the
+// policy is to normalize them and the encoder can produce the format of
+// the book
+func checkFormat(b Tracks, cfg *Config) Verdict {
+	tracks := tracksOf(b)
+	f := majorityFormat(tracks)
+	odd := oddTracks(tracks, f)
+	if len(odd) == 0 {
+		return Verdict{}
+	}
+	r := reject(ReasonMixedFormat, "%d of %d tracks differ from %v", len(odd), len(tracks), f)
+	if cfg.FormatPolicy == "normalize" {
+		_, err := encoderArgs(f)
+		if err == nil {
+			return Verdict{}
+		}
+		r.Detail += ": " + err.Error()
+	}
+	for _, t := range tracks {
+		if t.Format.Known() && t.Format != f {
+			r.Findings = append(r.Findings, Finding{File: t.Filename, Problem: "format " + t.Format.String()})
+		}
+	}
+	return r
+}
+
+// encoderArgs are the ffmpeg arguments which encode to f
+func encoderArgs(f AudioFormat) ([]string, error) {
+	var args []string
+	switch f.Codec {
+	case "AAC":
+		// the native encoder does LC only, not HE-AAC with SBR or PS
+		switch f.Profile {
+		case "":
+			args = []string{"-c:a", "aac"}
+		case "LC":
+			args = []string{"-c:a", "aac", "-profile:a", "aac_low"}
+		default:
+			return nil, fmt.Errorf("cannot encode to AAC profile %v", f.Profile)
+		}
+	case "ALAC":
+		args = []string{"-c:a", "alac"}
+	default:
+		return nil, fmt.Errorf("cannot encode to %v", f.Codec)
+	}
+	if f.SampleRate > 0 {
+		args = append(args, "-ar", strconv.Itoa(f.SampleRate))
+	}
+	if f.Channels > 0 {
+		args = append(args, "-ac", strconv.Itoa(f.Channels))
+	}
+	return args, nil
+}
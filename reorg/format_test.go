@@ -0,0 +1,48 @@
+package reorg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEncoderArgs(t *testing.T) {
+	for _, c := range []struct {
+		f    AudioFormat
+		want string // "" for an error
+	}{
+		{AudioFormat{Codec: "AAC", Profile: "LC", SampleRate: 44100, Channels: 2}, "-c:a aac -profile:a aac_low -ar 44100 -ac 2"},
+		{AudioFormat{Codec: "AAC"}, "-c:a aac"},
+		{AudioFormat{Codec: "ALAC", SampleRate: 48000}, "-c:a alac -ar 48000"},
+		{AudioFormat{Codec: "AAC", Profile: "HE-AAC / LC"}, ""},
+		{AudioFormat{Codec: "AAC", Profile: "LC SBR"}, ""},
+		{AudioFormat{Codec: "AAC", Profile: "HE-AACv2 / HE-AAC / LC"}, ""},
+		{AudioFormat{Codec: "MPEG Audio"}, ""},
+	} {
+		args, err := encoderArgs(c.f)
+		if got := strings.Join(args, " "); got != c.want || (err == nil) != (c.want != "") {
+			t.Errorf("%v: %q %v, want %q", c.f, got, err, c.want)
+		}
+	}
+}
+
+func TestCheckFormatNormalize(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.FormatPolicy = "normalize"
+	for _, c := range []struct {
+		profile string
+		ok      bool
+	}{{"LC", true}, {"HE-AAC / LC", false}} {
+		b := testBook(3, 1000)
+		for i := range b.Tracks {
+			b.Tracks[i].Format = AudioFormat{Codec: "AAC", Profile: c.profile, SampleRate: 44100, Channels: 2}
+		}
+		b.Tracks[2].Format.SampleRate = 22050
+		tracks := Tracks{}
+		for _, tr := range b.Tracks {
+			tracks[tr.Filename] = tr
+		}
+		if v := checkFormat(tracks, cfg); v.OK() != c.ok {
+			t.Errorf("%v: %v %v", c.profile, v.Reason, v.Detail)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package reorg
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The values of Track.Inferred
+const (
+	InferredTrack   = "track"
+	InferredDisk    = "disk"
+	InferredMaxDisk = "maxdisk"
+)
+
+// namePattern finds the numbers in a file name, disk and track are the
+// submatches holding them, 0 if the pattern has none
+type namePattern struct {
+	re    *regexp.Regexp
+	disk  int
+	track int
+}
+
+// namePatterns are tried in order on the file name without extension
+var namePatterns = []namePattern{
+	// "Disc 1 Track 05", "CD2-03", "cd 2 - 03 Title"
+	{regexp.MustCompile(`(?i)(?:^|[^a-z])(?:cd|disc|disk)\s*[-_.]?\s*(\d{1,2})\D+?(\d{1,3})(?:\D|$)`), 1, 2},
+	// "1-07", "2-03 Title"
+	{regexp.MustCompile(`^(\d{1,2})-(\d{1,3})(?:\D|$)`), 1, 2},
+	// "Track 05", "Titel 5"
+	{regexp.MustCompile(`(?i)(?:^|[^a-z])(?:track|titel|piste)\s*[-_.]?\s*(\d{1,3})(?:\D|$)`), 0, 1},
+	// "01 - Title", "03", "05. Title", "07_Title", but not the number
+	// in "100 Jahre Einsamkeit 01"
+	{regexp.MustCompile(`^(\d{1,3})(?:\s*-|[._]|$)`), 0, 1},
+	// "01 Title", zero padded, unlike "3 Musketiere"
+	{regexp.MustCompile(`^(0\d{1,2})\s`), 0, 1},
+	// "Title 01", "Title_03"
+	{regexp.MustCompile(`(?:^|[\s_-])(\d{1,3})$`), 0, 1},
+}
+
+// diskFolder finds the disk number in a folder like "CD 2" or "Disc2"
+var diskFolder = regexp.MustCompile(`(?i)(?:^|[^a-z])(?:cd|disc|disk)\s*[-_.]?\s*(\d{1,2})(?:\D|$)`)
+
+// numbersFromName guesses disk and track number from the name of a file
+// and the folder it is in, 0 if there is no guess
+func numbersFromName(filename string) (int, int) {
+	base := strings.TrimSpace(strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename)))
+	disk, track := 0, 0
+	for _, p := range namePatterns {
+		m := p.re.FindStringSubmatch(base)
+		if m == nil {
+			continue
+		}
+		if p.disk > 0 {
+			disk, _ = strconv.Atoi(m[p.disk])
+		}
+		track, _ = strconv.Atoi(m[p.track])
+		break
+	}
+	if disk == 0 {
+		if m := diskFolder.FindStringSubmatch(filepath.Base(filepath.Dir(filename))); m != nil {
+			disk, _ = strconv.Atoi(m[1])
+		}
+	}
+	return disk, track
+}
+
+// inferNumbers fills the track and disk numbers missing in the tags
+// from the file and folder names. If any disk number was inferred, the
+// tracks of the book without max disk get the highest disk number.
+// The inferred fields are listed in Track.Inferred.
+func (l Library) inferNumbers() {
+	for _, auth := range l.Authors() {
+		for _, book := range l.Books(auth) {
+			inferBookNumbers(l[auth][book])
+		}
+	}
+}
+
+func inferBookNumbers(b Tracks) {
+	anyDisk := false
+	maxdisk := 0
+	for name, t := range b {
+		disk, track := numbersFromName(t.Filename)
+		if t.TrackNo == 0 && track > 0 {
+			t.TrackNo = track
+			t.Inferred = append(t.Inferred, InferredTrack)
+		}
+		if t.DiskNo == 0 && disk > 0 {
+			t.DiskNo = disk
+			t.Inferred = append(t.Inferred, InferredDisk)
+			anyDisk = true
+		}
+		if t.DiskNo > maxdisk {
+			maxdisk = t.DiskNo
+		}
+		if len(t.Inferred) > 0 {
+			log.Debugf("[Infer] %v: disk %d track %d from the name, inferred %v", t.Filename, t.DiskNo, t.TrackNo, t.Inferred)
+		}
+		b[name] = t
+	}
+	if !anyDisk {
+		return
+	}
+	for name, t := range b {
+		if t.MaxDisk == 0 {
+			t.MaxDisk = maxdisk
+			t.Inferred = append(t.Inferred, InferredMaxDisk)
+			b[name] = t
+		}
+	}
+}
+
+// The values of Track.Inferred for the grouping, see PathPattern
+const (
+	InferredAuthor = "author"
+	InferredBook   = "book"
+)
+
+// PathPattern matches the folders of a file to find author and book,
+// e.g. "{author}/{book}" or "{author} - {book}". The pattern is matched
+// against as many of the innermost folders as it has, disk folders
+// like "CD 2" are skipped.
+type PathPattern struct {
+	re    *regexp.Regexp
+	depth int
+}
+
+// CompilePathPattern checks and compiles a pattern of Config.PathPatterns
+func CompilePathPattern(p string) (*PathPattern, error) {
+	segs := strings.Split(strings.Trim(p, "/"), "/")
+	for i, s := range segs {
+		s = regexp.QuoteMeta(s)
+		s = strings.Replace(s, `\{author\}`, `(?P<author>.+?)`, 1)
+		s = strings.Replace(s, `\{book\}`, `(?P<book>.+?)`, 1)
+		segs[i] = s
+	}
+	re, err := regexp.Compile("^" + strings.Join(segs, "/") + "$")
+	if err != nil {
+		return nil, err
+	}
+	if re.SubexpIndex("author") < 0 && re.SubexpIndex("book") < 0 {
+		return nil, fmt.Errorf("%q has neither {author} nor {book}", p)
+	}
+	return &PathPattern{re: re, depth: len(segs)}, nil
+}
+
+// match returns author and book from the folders of filename below
+// root, with whole only if the pattern covers all of these folders
+func (p *PathPattern) match(root string, filename string, whole bool) (string, string, bool) {
+	rel, err := filepath.Rel(root, filepath.Dir(filename))
+	if err != nil || rel == "." {
+		return "", "", false
+	}
+	dirs := strings.Split(filepath.ToSlash(rel), "/")
+	for len(dirs) > 0 && diskFolder.MatchString(dirs[len(dirs)-1]) {
+		dirs = dirs[:len(dirs)-1]
+	}
+	if len(dirs) < p.depth || (whole && len(dirs) != p.depth) {
+		return "", "", false
+	}
+	m := p.re.FindStringSubmatch(strings.Join(dirs[len(dirs)-p.depth:], "/"))
+	if m == nil {
+		return "", "", false
+	}
+	var author, book string
+	if i := p.re.SubexpIndex("author"); i >= 0 {
+		author = strings.TrimSpace(m[i])
+	}
+	if i := p.re.SubexpIndex("book"); i >= 0 {
+		book = strings.TrimSpace(m[i])
+	}
+	return author, book, true
+}
+
+// inferAuthorBook sets author and album of a track without them from
+// the first of the patterns which covers all its folders below root,
+// e.g. "{author} - {book}" for a book folder right below root and
+// "{author}/{book}" one level deeper. If none does, the first which
+// matches the innermost folders wins.
+func inferAuthorBook(t *Track, root string, patterns []*PathPattern) {
+	if t.Author != "" && t.Album != "" {
+		return
+	}
+	for _, whole := range []bool{true, false} {
+		for _, p := range patterns {
+			author, book, ok := p.match(root, t.Filename, whole)
+			if !ok {
+				continue
+			}
+			if t.Author == "" && author != "" {
+				t.Author = author
+				t.Inferred = append(t.Inferred, InferredAuthor)
+			}
+			if t.Album == "" && book != "" {
+				t.Album = book
+				t.Inferred = append(t.Inferred, InferredBook)
+			}
+			log.Debugf("[Infer] %v: author %q book %q from the folders", t.Filename, t.Author, t.Album)
+			return
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package reorg
+
+import "testing"
+
+func TestInferAuthorBook(t *testing.T) {
+	patterns, err := DefaultConfig().pathPatterns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		file         string
+		author, book string
+	}{
+		{"/lib/King/It/01.m4a", "King", "It"},
+		{"/lib/King - It/01.m4a", "King", "It"},
+		{"/lib/King - It/CD 2/01.m4a", "King", "It"},
+		{"/lib/King/It/CD2/01.m4a", "King", "It"},
+		{"/lib/Rowling/Harry Potter - Band 1/01.m4a", "Rowling", "Harry Potter - Band 1"},
+		{"/lib/Rowling/Harry Potter - Band 1/CD 1/01.m4a", "Rowling", "Harry Potter - Band 1"},
+		{"/lib/Audio/Rowling/Harry Potter - Band 1/01.m4a", "Rowling", "Harry Potter - Band 1"},
+		{"/lib/Incoming/King - It/01.m4a", "Incoming", "King - It"},
+		{"/lib/Audio/New/King - It/01.m4a", "New", "King - It"},
+		{"/lib/It/01.m4a", "", ""},
+		{"/lib/01.m4a", "", ""},
+	} {
+		tr := Track{Filename: c.file}
+		inferAuthorBook(&tr, "/lib", patterns)
+		if tr.Author != c.author || tr.Album != c.book {
+			t.Errorf("%v: %q %q, want %q %q", c.file, tr.Author, tr.Album, c.author, c.book)
+		}
+	}
+}
+
+func TestNumbersFromName(t *testing.T) {
+	for _, c := range []struct {
+		file        string
+		disk, track int
+	}{
+		{"01 - Title.m4a", 0, 1},
+		{"03.m4a", 0, 3},
+		{"05. Title.m4a", 0, 5},
+		{"07_Title.m4a", 0, 7},
+		{"12 -Title.m4a", 0, 12},
+		{"100 Jahre Einsamkeit 01.m4a", 0, 1},
+		{"100 Jahre Einsamkeit - 02.m4a", 0, 2},
+		{"Title_04.m4a", 0, 4},
+		{"01 - Kapitel 3.m4a", 0, 1},
+		{"01 Title.m4a", 0, 1},
+		{"01 Der Anfang.m4a", 0, 1},
+		{"012 Der Anfang 2.m4a", 0, 12},
+		{"/book/CD 1/07 Kapitel.m4a", 1, 7},
+		{"1984.m4a", 0, 0},
+		{"Orwell 1984.m4a", 0, 0},
+		{"3 Musketiere.m4a", 0, 0},
+		{"Title.m4a", 0, 0},
+		{"Track 05.m4a", 0, 5},
+		{"Titel 5 - Der Anfang.m4a", 0, 5},
+		{"Disc 1 Track 05.m4a", 1, 5},
+		{"CD2-03.m4a", 2, 3},
+		{"cd 2 - 03 Title.m4a", 2, 3},
+		{"1-07.m4a", 1, 7},
+		{"2-03 Title.m4a", 2, 3},
+		{"/book/CD 2/03.m4a", 2, 3},
+		{"/book/Disc3/Title 04.m4a", 3, 4},
+		{"/book/CD 2/Disc 1 Track 05.m4a", 1, 5},
+	} {
+		disk, track := numbersFromName(c.file)
+		if disk != c.disk || track != c.track {
+			t.Errorf("%v: disk %d track %d, want %d %d", c.file, disk, track, c.disk, c.track)
+		}
+	}
+}
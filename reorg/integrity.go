@@ -0,0 +1,364 @@
+package reorg
+
+import (
+	"fmt"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Reason tells why a book is rejected
+type Reason string
+
+// The reasons a book is rejected by the integrity checks
+const (
+	ReasonSingleFile          Reason = "single-file"
+	ReasonAlreadyLongEnough   Reason = "already-long-enough"
+	ReasonInconsistentMaxDisk Reason = "inconsistent-maxdisk"
+	ReasonMissingDisk         Reason = "missing-disk"
+	ReasonNoDiskInfo          Reason = "no-disk-info"
+	ReasonMixedDiskInfo       Reason = "mixed-disk-info"
+	ReasonNoTrackNumber       Reason = "no-track-number"
+	ReasonDuplicateTrack      Reason = "duplicate-track"
+	ReasonMissingTrack        Reason = "missing-track"
+	ReasonExtraTrack          Reason = "extra-track"
+	ReasonCorruptFile         Reason = "corrupt-file"
+	ReasonMixedFormat         Reason = "mixed-format"
+)
+
+// Verdict is the result of the integrity checks of one book
+type Verdict struct {
+	Reason   Reason        `json:"reason,omitempty"` // empty if the book is fine
+	Detail   string        `json:"detail,omitempty"`
+	Findings []Finding     `json:"findings,omitempty"` // per file, see DeepCheck
+	Disks    []DiskVerdict `json:"disks,omitempty"`    // the track checks per disk
+}
+
+// OK tells if the book passed all checks
+func (v Verdict) OK() bool {
+	return v.Reason == ""
+}
+
+func reject(r Reason, format string, args ...interface{}) Verdict {
+	return Verdict{Reason: r, Detail: fmt.Sprintf(format, args...)}
+}
+
+// Verdicts of all books, keyed by author and book
+type Verdicts map[string]map[string]Verdict
+
+// Get returns the verdict of a book
+func (v Verdicts) Get(author string, book string) Verdict {
+	return v[author][book]
+}
+
+// Verify runs the integrity checks on all books without changing the
+// library
+func (l Library) Verify(cfg *Config) Verdicts {
+	v := make(Verdicts)
+	for _, auth := range l.Authors() {
+		v[auth] = make(map[string]Verdict)
+		for _, book := range l.Books(auth) {
+			v[auth][book] = CheckBook(auth, book, l[auth][book], cfg)
+		}
+	}
+	return v
+}
+
+// RemoveRejected drops all books which did not pass the checks
+func (l Library) RemoveRejected(v Verdicts) {
+	for auth := range v {
+		for book := range v[auth] {
+			if !v[auth][book].OK() {
+				l.Remove(auth, book)
+			}
+		}
+	}
+}
+
+// CheckIntegrity removes all books from the library which are not worth
+// joining or are incomplete.
+func (l Library) CheckIntegrity(cfg *Config) Verdicts {
+	v := l.Verify(cfg)
+	l.RemoveRejected(v)
+	return v
+}
+
+// CheckBook tells if the tracks of a book can be joined
+func CheckBook(auth string, book string, b Tracks, cfg *Config) Verdict {
+	//      is this already "done"?
+	if v := areThereAnyPartsToJoin(auth, book, b); !v.OK() {
+		return v
+	}
+	if v := allreadyLongEnough(auth, book, b, cfg); !v.OK() {
+		return v
+	}
+	if v := checkMaxDiskSetAndAllEqual(auth, book, b); !v.OK() {
+		return v
+	}
+	if v := checkFormat(b, cfg); !v.OK() {
+		log.Warnf("[Format]: %v %v: %v", auth, book, v.Detail)
+		return v
+	}
+	return checkMaxTrackAndAllPresent(b, cfg)
+}
+
+func areThereAnyPartsToJoin(auth string, book string, b Tracks) Verdict {
+	if len(b) < 2 {
+		log.Warnf("[nothing to do:] %v %v has just one file - nothing to join\n", auth, book)
+		return reject(ReasonSingleFile, "just one file, nothing to join")
+	}
+	return Verdict{}
+}
+
+func allreadyLongEnough(auth string, book string, b Tracks, cfg *Config) Verdict {
+	x := getSomeKey(b)
+
+	if int(b[x].PlayLength) > cfg.AlreadyLongEnough {
+		log.Warnf("[nothing to do]: %s %s has already long parts\n", auth, book)
+		return reject(ReasonAlreadyLongEnough, "%v is %d ms long", x, int(b[x].PlayLength))
+	}
+	return Verdict{}
+}
+
+// withoutDisks tells if no track of the book has a disk number and
+// none a max disk above 1, such books are taken as disk 1 of 1
+func withoutDisks(b Tracks) bool {
+	for _, t := range b {
+		if t.DiskNo != 0 || t.MaxDisk > 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// highestDisk returns the highest disk number of b, the max disk of
+// books where no track has one
+func highestDisk(b Tracks) int {
+	highest := 0
+	for _, t := range b {
+		if t.DiskNo > highest {
+			highest = t.DiskNo
+		}
+	}
+	return highest
+}
+
+// checkDiskTagsComplete rejects books where some tracks have a disk
+// number and others have not, with a finding for each of the others. A
+// missing max disk is taken from the other tracks or the highest disk.
+func checkDiskTagsComplete(author string, book string, b Tracks) Verdict {
+	var findings []Finding
+	for _, name := range sortedKeys(b) {
+		t := b[name]
+		switch {
+		case t.DiskNo == 0 && t.MaxDisk == 0:
+			findings = append(findings, Finding{File: name, Problem: "no disk tags"})
+		case t.DiskNo == 0:
+			findings = append(findings, Finding{File: name, Problem: fmt.Sprintf("max disk %d without disk number", t.MaxDisk)})
+		}
+	}
+	if len(findings) == 0 {
+		return Verdict{}
+	}
+	for _, f := range findings {
+		log.Warnf("[MaxDisk]: %s:%s: %v: %v", author, book, f.File, f.Problem)
+	}
+	r := reject(ReasonMixedDiskInfo, "%d of %d tracks have incomplete disk tags", len(findings), len(b))
+	r.Findings = findings
+	return r
+}
+
+func checkMaxDiskSetAndAllEqual(author string, book string, b Tracks) Verdict {
+	log.Infof("[Max Disk]: Checking Track Integrity of \"%v: %v\"\n", author, book)
+	if withoutDisks(b) {
+		log.Infof("[MaxDisk]: %s:%s has no disk tags, taking it as disk 1 of 1", author, book)
+		return Verdict{}
+	}
+	if v := checkDiskTagsComplete(author, book, b); !v.OK() {
+		return v
+	}
+	maxdisk := 0
+	for track := range b {
+		tmax := b[track].MaxDisk
+		if tmax == 0 {
+			continue
+		}
+		if maxdisk == 0 {
+			maxdisk = tmax
+		}
+		if tmax != maxdisk {
+			log.Warnf("[MaxDisk]: author: %s, book %s has inconsistent max disk info\n", author, book)
+			return reject(ReasonInconsistentMaxDisk, "max disk %d and %d", maxdisk, tmax)
+		}
+		log.Debugf("[MaxDisk]: author: %s, book: %s, dsk:%v/[-> %v] track: %v/[%v]\n", author, book, b[track].DiskNo, b[track].MaxDisk, b[track].TrackNo, b[track].MaxTrack)
+	}
+	if maxdisk == 0 {
+		maxdisk = highestDisk(b)
+		log.Infof("[MaxDisk]: %s:%s has no max disk, taking %d", author, book, maxdisk)
+	}
+	return checkAllDisksInSetPresent(author, book, b, maxdisk)
+}
+
+func checkAllDisksInSetPresent(author string, book string, b Tracks, maxdisk int) Verdict {
+	diskprsnt := make([]bool, maxdisk+1)
+	for track := range b {
+		if b[track].DiskNo > 0 && b[track].DiskNo <= maxdisk {
+			diskprsnt[b[track].DiskNo] = true
+		}
+	}
+	missing := []int{}
+	for j := 1; j <= maxdisk; j++ {
+		if diskprsnt[j] {
+			log.Debugf("%v %v disk  %v is present\n", author, book, j)
+		} else {
+			log.Errorf("%v %v disk no %v is missing - skipping book\n", author, book, j)
+			missing = append(missing, j)
+		}
+
+	}
+	if len(missing) > 0 {
+		return reject(ReasonMissingDisk, "disk %v of %d missing", missing, maxdisk)
+	}
+	return Verdict{}
+}
+
+// DiskVerdict is the result of the track checks of one disk
+type DiskVerdict struct {
+	Disk       int    `json:"disk"`
+	Files      int    `json:"files"`
+	MaxTrack   int    `json:"maxtrack"`
+	Missing    []int  `json:"missing,omitempty"`
+	Extras     []int  `json:"extras,omitempty"` // track 0 and above max track
+	Duplicates []int  `json:"duplicates,omitempty"`
+	Decision   string `json:"decision"`
+}
+
+// The values of Config.TrackPolicy
+const (
+	PolicyStrict         = "strict"
+	PolicyAllowGaps      = "allow-gaps"
+	PolicyAllowExtras    = "allow-extras"
+	PolicyIgnoreMaxTrack = "ignore-maxtrack"
+)
+
+// TrackPolicies are the allowed values of Config.TrackPolicy
+var TrackPolicies = []string{PolicyStrict, PolicyAllowGaps, PolicyAllowExtras, PolicyIgnoreMaxTrack}
+
+// checkDisk checks the track numbers of a disk. Strict checks 1 to the
+// highest track number and only warns about the max track, like the
+// other policies do without a max track or with ignore-maxtrack. With a
+// max track the other policies check 1 to max track. Duplicates are
+// always rejected, missing numbers unless gaps are allowed, track 0 and
+// numbers above the max track unless extras are allowed.
+func checkDisk(disk Tracks, cfg *Config) (DiskVerdict, Verdict) {
+	anykey := getSomeKey(disk)
+	d := disk[anykey]
+	dv := DiskVerdict{Disk: d.DiskNo, Files: len(disk)}
+	dv.MaxTrack = mostCommonInt(disk, func(t Track) int { return t.MaxTrack })
+	strict := len(cfg.TrackPolicy) == 0 || contains(cfg.TrackPolicy, PolicyStrict)
+	ignoreMax := contains(cfg.TrackPolicy, PolicyIgnoreMaxTrack)
+	if dv.MaxTrack != 0 && dv.MaxTrack != len(disk) && !ignoreMax {
+		log.Warningf("[Track] %v,%v,disk %v has maxtrack %v where %v are on disk", d.Author, d.Album, d.DiskNo, dv.MaxTrack, len(disk))
+	}
+	useMax := dv.MaxTrack > 0 && !strict && !ignoreMax
+	expected := 0
+	for _, t := range disk {
+		if t.TrackNo > expected {
+			expected = t.TrackNo
+		}
+	}
+	if useMax {
+		expected = dv.MaxTrack
+	}
+	count := map[int]int{}
+	for _, t := range disk {
+		count[t.TrackNo]++
+	}
+	for _, no := range sortedInts(count) {
+		if count[no] > 1 {
+			dv.Duplicates = append(dv.Duplicates, no)
+		}
+		if no == 0 || (useMax && no > expected) {
+			dv.Extras = append(dv.Extras, no)
+		}
+	}
+	for j := 1; j <= expected; j++ {
+		if count[j] == 0 {
+			dv.Missing = append(dv.Missing, j)
+		}
+	}
+	allowGaps := contains(cfg.TrackPolicy, PolicyAllowGaps)
+	allowExtras := contains(cfg.TrackPolicy, PolicyAllowExtras)
+	var v Verdict
+	switch {
+	case len(dv.Duplicates) > 0:
+		v = reject(ReasonDuplicateTrack, "disk %d: track %v used more than once", d.DiskNo, dv.Duplicates)
+	case count[0] > 0 && !allowExtras:
+		v = reject(ReasonNoTrackNumber, "disk %d: %d tracks without track number", d.DiskNo, count[0])
+	case len(dv.Extras) > 0 && !allowExtras:
+		v = reject(ReasonExtraTrack, "disk %d: track %v above max track %d", d.DiskNo, dv.Extras, dv.MaxTrack)
+	case len(dv.Missing) > 0 && !allowGaps:
+		v = reject(ReasonMissingTrack, "disk %d: track %v missing", d.DiskNo, dv.Missing)
+	}
+	switch {
+	case !v.OK():
+		dv.Decision = string(v.Reason)
+		log.Errorf("[Track]: %v,%v: %v", d.Author, d.Album, v.Detail)
+	case len(dv.Missing) > 0 || len(dv.Extras) > 0:
+		dv.Decision = "ok"
+		if len(dv.Missing) > 0 {
+			dv.Decision += fmt.Sprintf(", missing %v allowed", dv.Missing)
+		}
+		if len(dv.Extras) > 0 {
+			dv.Decision += fmt.Sprintf(", extra %v allowed", dv.Extras)
+		}
+		log.Warnf("Author: %v, Book: %v, Disk No. %v: %v", d.Author, d.Album, d.DiskNo, dv.Decision)
+	default:
+		dv.Decision = "ok"
+		log.Infof("Author: %v, Book: %v, Disk No. %v is complete and in order\n", d.Author, d.Album, d.DiskNo)
+	}
+	return dv, v
+}
+
+// diskDecision returns the decision of disk, books without disk tags
+// have just disk 1
+func (v Verdict) diskDecision(disk int) string {
+	for _, d := range v.Disks {
+		if d.Disk == disk || (disk == 0 && len(v.Disks) == 1) {
+			return d.Decision
+		}
+	}
+	return ""
+}
+
+func sortedInts(m map[int]int) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// checkMaxTrackAndAllPresent checks all disks, the decision of each disk
+// goes into the verdict, the first rejected disk rejects the book
+func checkMaxTrackAndAllPresent(b Tracks, cfg *Config) Verdict {
+	tracksByDisk := orderedDiskSet(b)
+	if tracksByDisk == nil {
+		return reject(ReasonNoDiskInfo, "tracks without a valid disk number")
+	}
+	var result Verdict
+	var disks []DiskVerdict
+	for disk := range tracksByDisk {
+		if len(tracksByDisk[disk]) == 0 {
+			continue
+		}
+		dv, v := checkDisk(tracksByDisk[disk], cfg)
+		disks = append(disks, dv)
+		if result.OK() && !v.OK() {
+			result = v
+		}
+	}
+	result.Disks = disks
+	return result
+}
@@ -0,0 +1,106 @@
+package reorg
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// diskOf returns the tracks of disk 1 with the track numbers nos
+func diskOf(maxTrack int, nos ...int) Tracks {
+	disk := Tracks{}
+	for i, no := range nos {
+		name := fmt.Sprintf("/src/%d.m4a", i)
+		disk[name] = Track{Author: "A", Album: "B", DiskNo: 1, MaxDisk: 1, TrackNo: no, MaxTrack: maxTrack, Filename: name}
+	}
+	return disk
+}
+
+func TestCheckDisk(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		policy  []string
+		max     int
+		nos     []int
+		reason  Reason
+		missing []int
+		extras  []int
+	}{
+		{"complete", nil, 3, []int{1, 2, 3}, "", nil, nil},
+		{"strict without max track", nil, 0, []int{1, 2, 3}, "", nil, nil},
+		{"strict short of max track", nil, 3, []int{1, 2}, "", nil, nil},
+		{"strict above max track", nil, 3, []int{1, 2, 3, 4}, "", nil, nil},
+		{"strict gap", nil, 3, []int{1, 2, 4}, ReasonMissingTrack, []int{3}, nil},
+		{"strict gap without max track", nil, 0, []int{1, 2, 4}, ReasonMissingTrack, []int{3}, nil},
+		{"strict track 0", nil, 0, []int{0, 1, 2}, ReasonNoTrackNumber, nil, []int{0}},
+		{"duplicate", nil, 0, []int{1, 2, 2, 4}, ReasonDuplicateTrack, []int{3}, nil},
+		{"gaps without max track", []string{PolicyAllowGaps}, 0, []int{1, 2, 4}, "", []int{3}, nil},
+		{"gaps ignore max track", []string{PolicyAllowGaps, PolicyIgnoreMaxTrack}, 3, []int{1, 2, 4}, "", []int{3}, nil},
+		{"gaps above max track", []string{PolicyAllowGaps}, 3, []int{1, 2, 4}, ReasonExtraTrack, []int{3}, []int{4}},
+		{"gaps missing last", []string{PolicyAllowGaps}, 3, []int{1, 2}, "", []int{3}, nil},
+		{"extras missing last", []string{PolicyAllowExtras}, 3, []int{1, 2}, ReasonMissingTrack, []int{3}, nil},
+		{"extras", []string{PolicyAllowExtras}, 2, []int{0, 1, 2, 3}, "", nil, []int{0, 3}},
+		{"extras without max track", []string{PolicyAllowExtras}, 0, []int{0, 1, 2}, "", nil, []int{0}},
+		{"ignore max track", []string{PolicyIgnoreMaxTrack}, 2, []int{1, 2, 3}, "", nil, nil},
+		{"gaps and extras", []string{PolicyAllowGaps, PolicyAllowExtras}, 3, []int{0, 1, 3, 5}, "", []int{2}, []int{0, 5}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			if c.policy != nil {
+				cfg.TrackPolicy = c.policy
+			}
+			dv, v := checkDisk(diskOf(c.max, c.nos...), cfg)
+			if v.Reason != c.reason {
+				t.Errorf("reason %q (%v), want %q", v.Reason, v.Detail, c.reason)
+			}
+			if !reflect.DeepEqual(dv.Missing, c.missing) || !reflect.DeepEqual(dv.Extras, c.extras) {
+				t.Errorf("missing %v extras %v, want %v %v", dv.Missing, dv.Extras, c.missing, c.extras)
+			}
+			if dv.MaxTrack != c.max || dv.Files != len(c.nos) || dv.Decision == "" {
+				t.Errorf("disk verdict %+v", dv)
+			}
+		})
+	}
+}
+
+// withDisks returns tracks with the disk and max disk numbers d, two
+// numbers per track
+func withDisks(d ...int) Tracks {
+	tracks := Tracks{}
+	for i := 0; i < len(d); i += 2 {
+		name := fmt.Sprintf("/src/%d.m4a", i/2)
+		tracks[name] = Track{DiskNo: d[i], MaxDisk: d[i+1], TrackNo: 1, Filename: name}
+	}
+	return tracks
+}
+
+func TestCheckMaxDisk(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		tracks   Tracks
+		reason   Reason
+		findings int
+	}{
+		{"complete", withDisks(1, 2, 2, 2), "", 0},
+		{"no disk tags", withDisks(0, 0, 0, 0), "", 0},
+		{"no disk numbers, max disk 1", withDisks(0, 1, 0, 1), "", 0},
+		{"no disk numbers, some max disk 1", withDisks(0, 1, 0, 0), "", 0},
+		{"no disk numbers, max disk 2", withDisks(0, 2, 0, 2), ReasonMixedDiskInfo, 2},
+		{"some disk numbers, max disk 1", withDisks(1, 1, 0, 1), ReasonMixedDiskInfo, 1},
+		{"no max disk", withDisks(1, 0, 2, 0), "", 0},
+		{"some max disk", withDisks(1, 2, 2, 0), "", 0},
+		{"no max disk, disk missing", withDisks(1, 0, 3, 0), ReasonMissingDisk, 0},
+		{"some without disk", withDisks(1, 2, 0, 0, 0, 2), ReasonMixedDiskInfo, 2},
+		{"inconsistent", withDisks(1, 2, 2, 3), ReasonInconsistentMaxDisk, 0},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			v := checkMaxDiskSetAndAllEqual("A", "B", c.tracks)
+			if v.Reason != c.reason || len(v.Findings) != c.findings {
+				t.Errorf("%q %v %v, want %q with %d findings", v.Reason, v.Detail, v.Findings, c.reason, c.findings)
+			}
+			if disks := orderedDiskSet(c.tracks); v.OK() && disks == nil {
+				t.Errorf("no disks")
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package reorg
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/heinrichgrt/m4areorg/ffmeta"
+	"github.com/heinrichgrt/m4areorg/mp4meta"
+	log "github.com/sirupsen/logrus"
+)
+
+// Joiner builds the target files of a plan with the help of Tools
+type Joiner struct {
+	Config *Config
+	Tools  Tools
+}
+
+// NewJoiner returns a Joiner for cfg using the external tools
+func NewJoiner(cfg *Config) *Joiner {
+	return &Joiner{Config: cfg, Tools: NewExecTools(cfg.ToolBinPath)}
+}
+
+// Join writes the joined, tagged and marked target files of a plan.
+// Every call works in its own directory below Config.TmpDir, so books can
+// be joined concurrently. The directory is removed when Join returns,
+// unless Config.KeepTemp is set. On error the partial output is left in
+// place, see RemoveOutput.
+func (j *Joiner) Join(ctx context.Context, p *Plan) error {
+	ws, err := j.newWorkspace()
+	if err != nil {
+		return err
+	}
+	defer j.removeWorkspace(p, ws)
+	if err := j.writeProcessingFiles(p, ws); err != nil {
+		return err
+	}
+	if err := j.linkSourceFiles(ctx, p, ws); err != nil {
+		return err
+	}
+	if _, err := j.makeTargetDir(p); err != nil {
+		return err
+	}
+	for n := 1; n <= len(p.Parts); n++ {
+		if err := j.joinWithFfmpeg(ctx, p, ws, n); err != nil {
+			return err
+		}
+	}
+	return j.tagParts(ctx, p)
+}
+
+// BookLog returns a logger which tags all entries with the book
+func BookLog(b *Book) *log.Entry {
+	return log.WithFields(log.Fields{"author": b.Author, "book": b.Title})
+}
+
+// TargetDir is the directory the target files of a book go to
+func (j *Joiner) TargetDir(b *Book) string {
+	return j.Config.TargetDir + "/" + b.Author + "/" + b.Title
+}
+
+// TargetFile is the name of the joined file for part n (starting with 1)
+func (j *Joiner) TargetFile(p *Plan, n int) string {
+	tf := j.TargetDir(p.Book) + "/" + p.Book.Title
+	if len(p.Parts) > 1 {
+		tf = tf + "_part_" + strconv.Itoa(n)
+	}
+	return tf + ".m4a"
+}
+
+// Outputs returns the target files of all parts of a plan
+func (j *Joiner) Outputs(p *Plan) []string {
+	o := make([]string, 0, len(p.Parts))
+	for n := 1; n <= len(p.Parts); n++ {
+		o = append(o, j.TargetFile(p, n))
+	}
+	return o
+}
+
+// RemoveOutput cleans up the partial output of a failed book
+func (j *Joiner) RemoveOutput(p *Plan) {
+	for n := 1; n <= len(p.Parts); n++ {
+		tf := j.TargetFile(p, n)
+		if err := os.Remove(tf); err != nil && !os.IsNotExist(err) {
+			log.Warnf("cannot remove %v: %v", tf, err)
+		}
+	}
+	// only removes the directory if nothing else is left in there
+	os.Remove(j.TargetDir(p.Book))
+}
+
+// newWorkspace creates a fresh temp directory for one book
+func (j *Joiner) newWorkspace() (string, error) {
+	err := os.MkdirAll(j.Config.TmpDir, 0700)
+	if err != nil {
+		return "", fmt.Errorf("cannot use %v as temp dir: %w", j.Config.TmpDir, err)
+	}
+	return ioutil.TempDir(j.Config.TmpDir, "book-")
+}
+
+func (j *Joiner) removeWorkspace(p *Plan, ws string) {
+	if j.Config.KeepTemp {
+		BookLog(p.Book).Infof("keeping temp dir %v", ws)
+		return
+	}
+	if err := os.RemoveAll(ws); err != nil {
+		BookLog(p.Book).Warnf("cannot remove temp dir %v: %v", ws, err)
+	}
+}
+
+func openFiles(ws string, p int, prefix string) ([]*os.File, error) {
+	ts := make([]*os.File, 0, p)
+	for f := 0; f < p; f++ {
+		fo, err := os.Create(ws + "/" + prefix + strconv.Itoa(f+1) + ".txt")
+		if err != nil {
+			closeFiles(ts)
+			return nil, err
+		}
+		ts = append(ts, fo)
+	}
+	return ts, nil
+}
+
+func closeFiles(fs []*os.File) error {
+	var r error
+	for f := range fs {
+		if err := fs[f].Close(); err != nil && r == nil {
+			r = err
+		}
+	}
+	return r
+}
+
+// partMetadata returns the FFMETADATA of part n (starting with 1): the
+// book tags and the chapters of the part
+func partMetadata(p *Plan, n int, cfg *Config) *ffmeta.Metadata {
+	book := p.Book
+	m := &ffmeta.Metadata{Global: ffmeta.Tags{
+		{Key: "major_brand", Value: "M4A"},
+		{Key: "minor_version", Value: "0"},
+		{Key: "compatible_brands", Value: "M4A mp42isom"},
+		{Key: "artist", Value: book.Author},
+		{Key: "album", Value: book.Title},
+	}}
+	narratorAsComposer := book.Narrator != "" && cfg.NarratorTag == "composer"
+	for _, bt := range BookTags {
+		v, ok := book.Tags[bt.Key]
+		if !ok || (bt.Key == "composer" && narratorAsComposer) {
+			continue
+		}
+		m.Global.Set(bt.Key, v)
+	}
+	if narratorAsComposer {
+		m.Global.Set("composer", book.Narrator)
+	}
+	m.Global.Set("mediatype", "2")
+	m.Global.Set("Encoding Params", "vers")
+	m.Global.Set("title", book.Title+" "+p.Labels.Part+" "+strconv.Itoa(n))
+	for _, c := range p.Chapters {
+		if c.Part != n {
+			continue
+		}
+		m.Chapters = append(m.Chapters, ffmeta.Chapter{
+			TimeBase: "1/1000",
+			Start:    int64(c.Start),
+			End:      int64(c.End),
+			Tags:     ffmeta.Tags{{Key: "title", Value: c.Title}},
+		})
+	}
+	return m
+}
+
+// writeProcessingFiles writes the concat lists and the metadata files
+// for ffmpeg to the workspace ws.
+func (j *Joiner) writeProcessingFiles(p *Plan, ws string) error {
+	for n := 1; n <= len(p.Parts); n++ {
+		if err := partMetadata(p, n, j.Config).WriteFile(metadataFile(ws, n)); err != nil {
+			return err
+		}
+	}
+	ts, err := openFiles(ws, len(p.Parts), "ffmpegfilelist_part_")
+	if err != nil {
+		return err
+	}
+	err = writeLists(p, ts)
+	if cerr := closeFiles(ts); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// metadataFile is the FFMETADATA file of part n in the workspace ws
+func metadataFile(ws string, n int) string {
+	return ws + "/ffmpegmetainfo_part_" + strconv.Itoa(n) + ".txt"
+}
+
+// writeLists writes the ffmpeg concat lists of all parts
+func writeLists(p *Plan, ts []*os.File) error {
+	for n := range p.Parts {
+		for _, t := range p.Parts[n] {
+			_, err := ts[n].WriteString(fmt.Sprintf("file '%s'\n", strconv.Itoa(t)+".m4a"))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// linkSourceFiles puts the tracks into the workspace as 0.m4a, 1.m4a,
+// ... With the normalize format policy the tracks not in the format of
+// the book are re-encoded, all others are linked.
+func (j *Joiner) linkSourceFiles(ctx context.Context, p *Plan, tmpdi string) error {
+	for f := range p.Book.Tracks {
+		t := p.Book.Tracks[f]
+		if j.Config.FormatPolicy == "normalize" && t.Format.Known() && t.Format != p.Book.Format {
+			BookLog(p.Book).Infof("re-encoding %v from %v to %v", t.Filename, t.Format, p.Book.Format)
+			if err := j.Tools.Convert(ctx, t.Filename, tmpdi+"/"+strconv.Itoa(f)+".m4a", p.Book.Format); err != nil {
+				return fmt.Errorf("cannot re-encode %v: %w", t.Filename, err)
+			}
+			continue
+		}
+		fp, err := filepath.Abs(p.Book.Tracks[f].Filename)
+		if err != nil {
+			return err
+		}
+		err = os.Symlink(fp, tmpdi+"/"+strconv.Itoa(f)+".m4a")
+		if err != nil {
+			return fmt.Errorf("cannot symlink %v to %v: %w", p.Book.Tracks[f].Filename, tmpdi+"/"+strconv.Itoa(f)+".m4a", err)
+		}
+	}
+	return nil
+}
+
+func (j *Joiner) makeTargetDir(p *Plan) (string, error) {
+
+	td := j.TargetDir(p.Book)
+	err := os.MkdirAll(td, 0755)
+	return td, err
+}
+
+// tagParts attaches the cover, marks the parts as audiobook and sets the
+// tags the ffmpeg mp4 muxer does not write
+func (j *Joiner) tagParts(ctx context.Context, p *Plan) error {
+	tags := TagSet{MediaKind: mp4meta.Audiobook, Text: map[string]string{}}
+	if p.Book.Narrator != "" && j.Config.NarratorTag == "narrator" {
+		tags.Text[mp4meta.Narrator] = p.Book.Narrator
+	}
+	if v := p.Book.Tags["publisher"]; v != "" {
+		tags.Text[mp4meta.Publisher] = v
+	}
+	if v := p.Book.Tags["language"]; v != "" {
+		tags.Text[mp4meta.LanguageKey] = v
+	}
+	if p.Book.Cover != nil {
+		data, err := p.Book.Cover.Data()
+		if err != nil {
+			BookLog(p.Book).Warnf("cannot read the cover: %v", err)
+		}
+		tags.Cover = data
+	}
+	for n := 1; n <= len(p.Parts); n++ {
+		if err := j.Tools.Tag(ctx, j.TargetFile(p, n), tags); err != nil {
+			return fmt.Errorf("tagging failed: %w", err)
+		}
+		BookLog(p.Book).Debugf("Successfully tagged %v", j.TargetFile(p, n))
+	}
+	return nil
+}
+
+func (j *Joiner) joinWithFfmpeg(ctx context.Context, p *Plan, ws string, n int) error {
+	pa := ws + "/"
+	tf := j.TargetFile(p, n)
+	BookLog(p.Book).Infof("starting to join %v\n", tf)
+	err := j.Tools.Concat(ctx, pa+"ffmpegfilelist_part_"+strconv.Itoa(n)+".txt",
+		metadataFile(ws, n), tf)
+	if err != nil {
+		return fmt.Errorf("join failed: %w", err)
+	}
+	BookLog(p.Book).Infof("Successfully created target audio file %v\n", tf)
+	return nil
+}
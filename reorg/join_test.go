@@ -0,0 +1,208 @@
+package reorg
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/heinrichgrt/m4areorg/ffmeta"
+)
+
+func testBook(n int, length float32) *Book {
+	b := &Book{Author: "Doe, John", Title: "The Book", Narrator: "Jane Roe",
+		Tags: map[string]string{"genre": "Hörbuch", "publisher": "Verlag"}}
+	for i := 0; i < n; i++ {
+		b.Tracks = append(b.Tracks, Track{
+			Author: b.Author, Album: b.Title, Title: "Track " + string(rune('A'+i)),
+			DiskNo: 1, MaxDisk: 1, TrackNo: i + 1, MaxTrack: n,
+			PlayLength: length, Filename: "/src/" + string(rune('a'+i)) + ".m4a",
+		})
+		b.Duration += int(length)
+	}
+	return b
+}
+
+func testJoiner(t *testing.T) (*Joiner, *FakeTools) {
+	dir := t.TempDir()
+	cfg := DefaultConfig()
+	cfg.TmpDir = filepath.Join(dir, "tmp")
+	cfg.TargetDir = filepath.Join(dir, "target")
+	cfg.Lang = "en"
+	fake := &FakeTools{}
+	return &Joiner{Config: cfg, Tools: fake}, fake
+}
+
+func mustPlan(t *testing.T, b *Book, cfg *Config) *Plan {
+	p, err := NewPlan(b, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func callsOf(calls []ToolCall, op string) []ToolCall {
+	var l []ToolCall
+	for _, c := range calls {
+		if c.Op == op {
+			l = append(l, c)
+		}
+	}
+	return l
+}
+
+func tempDirs(t *testing.T, j *Joiner) []string {
+	fis, err := ioutil.ReadDir(j.Config.TmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var l []string
+	for _, fi := range fis {
+		l = append(l, fi.Name())
+	}
+	return l
+}
+
+func TestJoinOnePart(t *testing.T) {
+	j, fake := testJoiner(t)
+	b := testBook(3, 1000)
+	b.Cover = &Cover{Ext: "jpg", Source: filepath.Join(t.TempDir(), "cover.jpg")}
+	if err := ioutil.WriteFile(b.Cover.Source, []byte("\xff\xd8\xffjpeg"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := mustPlan(t, b, j.Config)
+	if err := j.Join(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+	calls := fake.Calls()
+	concat := callsOf(calls, "Concat")
+	if len(concat) != 1 {
+		t.Fatalf("got %d Concat calls, want 1", len(concat))
+	}
+	list := concat[0].Inputs[concat[0].Args[0]]
+	if want := "file '0.m4a'\nfile '1.m4a'\nfile '2.m4a'\n"; list != want {
+		t.Errorf("concat list %q, want %q", list, want)
+	}
+	if target := concat[0].Args[2]; target != j.TargetFile(p, 1) || !strings.HasSuffix(target, "/The Book.m4a") {
+		t.Errorf("target %v", target)
+	}
+	m, err := ffmeta.Parse(strings.NewReader(concat[0].Inputs[concat[0].Args[1]]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, want := range map[string]string{
+		"artist": "Doe, John", "album": "The Book", "composer": "Jane Roe",
+		"genre": "Hörbuch", "title": "The Book Part 1",
+	} {
+		if v, _ := m.Global.Get(k); v != want {
+			t.Errorf("%v = %q, want %q", k, v, want)
+		}
+	}
+	if len(m.Chapters) != 3 {
+		t.Fatalf("got %d chapters, want 3", len(m.Chapters))
+	}
+	for i, c := range m.Chapters {
+		title, _ := c.Tags.Get("title")
+		if c.Start != int64(i*1000) || c.End != int64(i*1000+1000) || title != b.Tracks[i].Title {
+			t.Errorf("chapter %d: %v-%v %q", i, c.Start, c.End, title)
+		}
+	}
+	tag := callsOf(calls, "Tag")
+	if len(tag) != 1 {
+		t.Fatalf("got %d Tag calls, want 1", len(tag))
+	}
+	want := []string{j.TargetFile(p, 1), "cover=7 bytes", "mediakind=2", "\xa9pub=Verlag"}
+	if strings.Join(tag[0].Args, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Tag args %q, want %q", tag[0].Args, want)
+	}
+	if d := tempDirs(t, j); len(d) != 0 {
+		t.Errorf("temp dirs left: %v", d)
+	}
+}
+
+func TestJoinParts(t *testing.T) {
+	j, fake := testJoiner(t)
+	j.Config.MaxDuration = 2500
+	p := mustPlan(t, testBook(4, 1000), j.Config)
+	if err := j.Join(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+	concat := callsOf(fake.Calls(), "Concat")
+	if len(concat) != len(p.Parts) || len(p.Parts) != 2 {
+		t.Fatalf("got %d Concat calls for %d parts, want 2", len(concat), len(p.Parts))
+	}
+	for n, c := range concat {
+		if c.Args[2] != j.TargetFile(p, n+1) || !strings.HasSuffix(c.Args[2], "_part_"+string(rune('1'+n))+".m4a") {
+			t.Errorf("part %d target %v", n+1, c.Args[2])
+		}
+		m, err := ffmeta.Parse(strings.NewReader(c.Inputs[c.Args[1]]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(m.Chapters) != len(p.Parts[n]) || m.Chapters[0].Start != 0 {
+			t.Errorf("part %d: chapters %v", n+1, m.Chapters)
+		}
+	}
+	if n := len(callsOf(fake.Calls(), "Tag")); n != 2 {
+		t.Errorf("got %d Tag calls, want 2", n)
+	}
+}
+
+func TestJoinKeepTemp(t *testing.T) {
+	j, _ := testJoiner(t)
+	j.Config.KeepTemp = true
+	if err := j.Join(context.Background(), mustPlan(t, testBook(2, 1000), j.Config)); err != nil {
+		t.Fatal(err)
+	}
+	d := tempDirs(t, j)
+	if len(d) != 1 {
+		t.Fatalf("temp dirs %v, want one", d)
+	}
+	for _, f := range []string{"ffmpegfilelist_part_1.txt", "ffmpegmetainfo_part_1.txt", "0.m4a", "1.m4a"} {
+		if _, err := os.Lstat(filepath.Join(j.Config.TmpDir, d[0], f)); err != nil {
+			t.Errorf("%v: %v", f, err)
+		}
+	}
+}
+
+func TestJoinFailure(t *testing.T) {
+	j, fake := testJoiner(t)
+	fake.Fail = map[string]error{"Concat": errors.New("boom")}
+	err := j.Join(context.Background(), mustPlan(t, testBook(2, 1000), j.Config))
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("err %v, want boom", err)
+	}
+	if n := len(callsOf(fake.Calls(), "Tag")); n != 0 {
+		t.Errorf("got %d Tag calls after failed join", n)
+	}
+	if d := tempDirs(t, j); len(d) != 0 {
+		t.Errorf("temp dirs left: %v", d)
+	}
+}
+
+func TestJoinCoverGone(t *testing.T) {
+	j, fake := testJoiner(t)
+	b := testBook(2, 1000)
+	b.Cover = &Cover{Ext: "jpg", Source: filepath.Join(t.TempDir(), "cover.jpg")}
+	if err := j.Join(context.Background(), mustPlan(t, b, j.Config)); err != nil {
+		t.Fatal(err)
+	}
+	tag := callsOf(fake.Calls(), "Tag")
+	if len(tag) != 1 || tag[0].Args[1] != "cover=0 bytes" {
+		t.Errorf("Tag calls %v", tag)
+	}
+}
+
+func TestNewPlanGuards(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxDuration = 0
+	if _, err := NewPlan(testBook(2, 1000), cfg); err == nil {
+		t.Errorf("no error for max duration 0")
+	}
+	if _, err := NewPlan(testBook(0, 1000), DefaultConfig()); err == nil {
+		t.Errorf("no error for a book without tracks")
+	}
+}
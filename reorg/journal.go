@@ -0,0 +1,158 @@
+package reorg
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// JournalFile is the name of the journal in the target dir
+const JournalFile = ".m4areorg-journal.json"
+
+// SourceFile identifies one source file by path, size and mtime
+type SourceFile struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// JournalEntry records one finished book
+type JournalEntry struct {
+	Author   string       `json:"author"`
+	Book     string       `json:"book"`
+	Sources  []SourceFile `json:"sources"`
+	Parts    [][]int      `json:"parts"`
+	Chapters []Chapter    `json:"chapters"`
+	Outputs  []string     `json:"outputs"`
+	Finished time.Time    `json:"finished"`
+}
+
+// Journal keeps track of the finished books, so a new run can skip them.
+// The entries are keyed by a hash over the source files of the book.
+// It is safe for concurrent use.
+type Journal struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]*JournalEntry
+}
+
+// OpenJournal reads the journal in dir, a missing journal is empty
+func OpenJournal(dir string) (*Journal, error) {
+	j := &Journal{path: filepath.Join(dir, JournalFile), entries: map[string]*JournalEntry{}}
+	data, err := ioutil.ReadFile(j.path)
+	if os.IsNotExist(err) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &j.entries); err != nil {
+		return nil, fmt.Errorf("%v: %w", j.path, err)
+	}
+	return j, nil
+}
+
+func sourceFiles(b *Book) ([]SourceFile, error) {
+	s := make([]SourceFile, 0, len(b.Tracks))
+	for _, t := range b.Tracks {
+		fi, err := os.Stat(t.Filename)
+		if err != nil {
+			return nil, err
+		}
+		p, err := filepath.Abs(t.Filename)
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, SourceFile{Path: p, Size: fi.Size(), ModTime: fi.ModTime().UTC()})
+	}
+	return s, nil
+}
+
+func sourceKey(s []SourceFile) string {
+	sorted := append([]SourceFile(nil), s...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Path < sorted[j].Path })
+	h := sha256.New()
+	for _, f := range sorted {
+		fmt.Fprintf(h, "%s\x00%d\x00%d\n", f.Path, f.Size, f.ModTime.UnixNano())
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func samePlan(e *JournalEntry, p *Plan) bool {
+	a, _ := json.Marshal([]interface{}{e.Parts, e.Chapters})
+	b, _ := json.Marshal([]interface{}{p.Parts, p.Chapters})
+	return bytes.Equal(a, b)
+}
+
+// Done tells if the book of p was finished with the same sources and the
+// same plan, and all its outputs are still there.
+func (j *Journal) Done(p *Plan) bool {
+	s, err := sourceFiles(p.Book)
+	if err != nil {
+		return false
+	}
+	j.mu.Lock()
+	e, ok := j.entries[sourceKey(s)]
+	j.mu.Unlock()
+	if !ok || !samePlan(e, p) {
+		return false
+	}
+	for _, o := range e.Outputs {
+		if _, err := os.Stat(o); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Record stores the book of p as finished with outputs and saves the
+// journal. Older entries of the same book are dropped.
+func (j *Journal) Record(p *Plan, outputs []string) error {
+	s, err := sourceFiles(p.Book)
+	if err != nil {
+		return err
+	}
+	e := &JournalEntry{
+		Author:   p.Book.Author,
+		Book:     p.Book.Title,
+		Sources:  s,
+		Parts:    p.Parts,
+		Chapters: p.Chapters,
+		Outputs:  outputs,
+		Finished: time.Now().UTC(),
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for k, old := range j.entries {
+		if old.Author == e.Author && old.Book == e.Book {
+			delete(j.entries, k)
+		}
+	}
+	j.entries[sourceKey(s)] = e
+	return j.save()
+}
+
+// save writes the journal via a temp file, a crash never leaves a
+// half written journal behind
+func (j *Journal) save() error {
+	data, err := json.MarshalIndent(j.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(j.path), 0755); err != nil {
+		return err
+	}
+	tmp := j.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, j.path)
+}
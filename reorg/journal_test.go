@@ -0,0 +1,136 @@
+package reorg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// journalPlan returns a plan of a book with real source files in dir and
+// one written output
+func journalPlan(t *testing.T, dir string, title string) (*Plan, []string) {
+	b := testBook(3, 1000)
+	b.Title = title
+	for i := range b.Tracks {
+		b.Tracks[i].Filename = filepath.Join(dir, title+filepath.Base(b.Tracks[i].Filename))
+		if err := ioutil.WriteFile(b.Tracks[i].Filename, []byte("audio"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out := filepath.Join(dir, title+".out.m4a")
+	if err := ioutil.WriteFile(out, []byte("joined"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return mustPlan(t, b, DefaultConfig()), []string{out}
+}
+
+func recordAndReopen(t *testing.T, dir string, p *Plan, outputs []string) *Journal {
+	j, err := OpenJournal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.Done(p) {
+		t.Fatalf("done before Record")
+	}
+	if err := j.Record(p, outputs); err != nil {
+		t.Fatal(err)
+	}
+	j, err = OpenJournal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return j
+}
+
+func TestJournalRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	p, outputs := journalPlan(t, dir, "A")
+	j := recordAndReopen(t, dir, p, outputs)
+	if !j.Done(p) {
+		t.Errorf("not done after Record and OpenJournal")
+	}
+	if len(j.entries) != 1 {
+		t.Fatalf("%d entries, want 1", len(j.entries))
+	}
+	for _, e := range j.entries {
+		if !samePlan(e, p) {
+			t.Errorf("the re-read entry has another plan: %+v", e)
+		}
+		if e.Author != p.Book.Author || e.Book != p.Book.Title || len(e.Sources) != 3 || e.Outputs[0] != outputs[0] {
+			t.Errorf("entry %+v", e)
+		}
+	}
+	// another plan of the same sources
+	cfg := DefaultConfig()
+	cfg.MaxDuration = 1500
+	if other := mustPlan(t, p.Book, cfg); j.Done(other) {
+		t.Errorf("done with another plan")
+	}
+}
+
+func TestJournalChangedSource(t *testing.T) {
+	for name, change := range map[string]func(string) error{
+		"size": func(f string) error { return ioutil.WriteFile(f, []byte("more audio"), 0644) },
+		"mtime": func(f string) error {
+			return os.Chtimes(f, time.Now(), time.Now().Add(-time.Hour))
+		},
+		"deleted": os.Remove,
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			p, outputs := journalPlan(t, dir, "A")
+			j := recordAndReopen(t, dir, p, outputs)
+			if err := change(p.Book.Tracks[1].Filename); err != nil {
+				t.Fatal(err)
+			}
+			if j.Done(p) {
+				t.Errorf("done after the %v of a source changed", name)
+			}
+		})
+	}
+}
+
+func TestJournalOutputDeleted(t *testing.T) {
+	dir := t.TempDir()
+	p, outputs := journalPlan(t, dir, "A")
+	j := recordAndReopen(t, dir, p, outputs)
+	if err := os.Remove(outputs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if j.Done(p) {
+		t.Errorf("done without its output")
+	}
+}
+
+func TestJournalReplacesEntry(t *testing.T) {
+	dir := t.TempDir()
+	p, outputs := journalPlan(t, dir, "A")
+	other, otherOutputs := journalPlan(t, dir, "B")
+	j := recordAndReopen(t, dir, p, outputs)
+	if err := j.Record(other, otherOutputs); err != nil {
+		t.Fatal(err)
+	}
+	// the sources of A change, it is joined and recorded again
+	if err := ioutil.WriteFile(p.Book.Tracks[0].Filename, []byte("new audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	j = recordAndReopen(t, dir, p, outputs)
+	if len(j.entries) != 2 {
+		t.Errorf("%d entries, want one for A and B", len(j.entries))
+	}
+	if !j.Done(p) || !j.Done(other) {
+		t.Errorf("done A %v B %v", j.Done(p), j.Done(other))
+	}
+}
+
+func TestJournalBroken(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, JournalFile), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenJournal(dir); err == nil {
+		t.Errorf("no error for a broken journal")
+	}
+}
@@ -0,0 +1,96 @@
+package reorg
+
+import (
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Labels are the words the parts and chapters are named with, e.g.
+// "Book Part 2" and "Chapter 3"
+type Labels struct {
+	Part    string `toml:"part"`
+	Chapter string `toml:"chapter"`
+}
+
+// DefaultLabels are the built in label sets keyed by ISO 639-1 code,
+// Config.Labels adds to and overrides them
+var DefaultLabels = map[string]Labels{
+	"de": {Part: "Teil", Chapter: "Kapitel"},
+	"en": {Part: "Part", Chapter: "Chapter"},
+	"fr": {Part: "Partie", Chapter: "Chapitre"},
+}
+
+// FallbackLang is used if the language of a book is unknown
+const FallbackLang = "en"
+
+// languageCodes maps the ISO 639-2 codes and the names found in tags to
+// ISO 639-1
+var languageCodes = map[string]string{
+	"deu": "de", "ger": "de", "german": "de", "deutsch": "de",
+	"eng": "en", "english": "en",
+	"fra": "fr", "fre": "fr", "french": "fr", "français": "fr", "francais": "fr",
+}
+
+// normalizeLang turns "deu", "German" or "de-AT" into "de"
+func normalizeLang(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if i := strings.IndexAny(s, "-_"); i > 0 {
+		s = s[:i]
+	}
+	if c, ok := languageCodes[s]; ok {
+		return c
+	}
+	return s
+}
+
+// labelSet returns the labels of lang, the empty ones taken from the
+// built in set of lang or of FallbackLang
+func (c *Config) labelSet(lang string) (Labels, bool) {
+	l, custom := c.Labels[lang]
+	d, builtin := DefaultLabels[lang]
+	if !builtin {
+		d = DefaultLabels[FallbackLang]
+	}
+	if l.Part == "" {
+		l.Part = d.Part
+	}
+	if l.Chapter == "" {
+		l.Chapter = d.Chapter
+	}
+	return l, custom || builtin
+}
+
+// knownLang tells if there is a label set for lang
+func (c *Config) knownLang(lang string) bool {
+	_, ok := c.labelSet(lang)
+	return ok
+}
+
+// BookLanguage returns the language the parts and chapters of b are
+// named in: Config.Lang, or with "auto" the language tag of the book
+func (c *Config) BookLanguage(b *Book) string {
+	if c.Lang != "auto" {
+		return normalizeLang(c.Lang)
+	}
+	tag := b.Tags["language"]
+	if tag == "" {
+		return FallbackLang
+	}
+	lang := normalizeLang(tag)
+	if !c.knownLang(lang) {
+		log.Infof("%v: %v: no labels for language %q, using %v", b.Author, b.Title, tag, FallbackLang)
+		return FallbackLang
+	}
+	return lang
+}
+
+// BookLabels returns the labels of b, Config.ChapterTitle overrides the
+// chapter label
+func (c *Config) BookLabels(b *Book) Labels {
+	l, _ := c.labelSet(c.BookLanguage(b))
+	if c.ChapterTitle != "" {
+		l.Chapter = strings.TrimSpace(c.ChapterTitle)
+	}
+	return l
+}
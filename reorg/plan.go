@@ -0,0 +1,268 @@
+package reorg
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Book is a set of tracks ordered by disk and position on disk
+type Book struct {
+	Author   string
+	Title    string
+	Duration int      // total play time in ms
+	Disks    []Tracks // the content ordered by disk
+	Tracks   []Track  // all tracks ordered by disk and pos on disk
+	Cover    *Cover   // nil if no cover was found
+	Narrator string
+	Format   AudioFormat // of most tracks
+	// Tags are the BookTags all tracks agree on, TagConflicts the
+	// values of those they do not
+	Tags         map[string]string
+	TagConflicts map[string][]string
+}
+
+// Plan tells how a book is split into parts and where the chapters go
+type Plan struct {
+	Book      *Book
+	SplitTime int       // target length of a part in ms
+	Labels    Labels    // the parts and chapters are named with
+	Parts     [][]int   // index into Book.Tracks, one list per target part
+	Chapters  []Chapter // chapter marks in order of Book.Tracks, one or more per track
+}
+
+// Chapter is one chapter mark in a target part
+type Chapter struct {
+	Part  int // target part, starting with 1
+	Track int // index into Book.Tracks
+	Start int // in ms from the start of the part
+	End   int
+	Title string
+}
+
+// ErrNoDiskSet is returned for books without any disk information
+var ErrNoDiskSet = errors.New("no diskset information for this book")
+
+// NewBook orders the tracks of a book
+func NewBook(author string, title string, tracks Tracks) (*Book, error) {
+	b := &Book{
+		Author:   author,
+		Title:    title,
+		Duration: tracks.PlayTime(),
+		Disks:    orderedDiskSet(tracks),
+	}
+	// todo fix this it is quite ugly
+	if len(b.Disks) == 0 {
+		return nil, ErrNoDiskSet
+	}
+	var why []string
+	b.Tracks, why = orderedTracksOnBook(b.Disks)
+	for _, w := range why {
+		log.Debugf("[Order] %v: %v: %v", author, title, w)
+	}
+	b.Narrator = mostCommon(b.Tracks, func(t Track) string { return t.Narrator })
+	b.Format = majorityFormat(b.Tracks)
+	b.Tags, b.TagConflicts = CommonTags(b.Tracks)
+	for _, k := range conflictKeys(b.TagConflicts) {
+		log.Warnf("%v: %v: tracks disagree on %v: %q, left out", author, title, k, b.TagConflicts[k])
+	}
+	b.Cover = findCover(b.Tracks)
+	if b.Cover == nil {
+		log.Warnf("%v: %v has no cover", author, title)
+	}
+	return b, nil
+}
+
+// NewPlan splits a book into parts of at most cfg.MaxDuration. It fails
+// for a book without tracks or a max duration below 1.
+func NewPlan(b *Book, cfg *Config) (*Plan, error) {
+	if cfg.MaxDuration <= 0 {
+		return nil, fmt.Errorf("max duration %d ms is not positive", cfg.MaxDuration)
+	}
+	if len(b.Tracks) == 0 || b.Duration < 0 {
+		return nil, fmt.Errorf("%v: %v has no tracks to plan", b.Author, b.Title)
+	}
+	parts := howMuchParts(b.Duration, cfg.MaxDuration)
+	p := &Plan{
+		Book:      b,
+		SplitTime: splitLength(parts, b.Duration),
+		Parts:     make([][]int, parts),
+		Labels:    cfg.BookLabels(b),
+	}
+	splitByParts(p, cfg)
+	return p, nil
+}
+
+// mostCommon returns the most common value of field which is not empty,
+// on a tie the one of the earlier track wins
+func mostCommon(tracks []Track, field func(Track) string) string {
+	count := map[string]int{}
+	best := ""
+	for _, t := range tracks {
+		v := field(t)
+		if v == "" {
+			continue
+		}
+		count[v]++
+		if count[v] > count[best] {
+			best = v
+		}
+	}
+	return best
+}
+
+func howMuchParts(duration int, maxduration int) int {
+	p := int(math.Trunc(float64(duration)/(float64(maxduration)))) + 1
+	return p
+
+}
+
+func splitLength(p int, d int) int {
+	return int(d / p)
+
+}
+
+// splitByParts distributes the sorted tracks on the target parts and
+// calculates the chapter marks.
+func splitByParts(p *Plan, cfg *Config) {
+	book := p.Book
+	title := chapterTitler(book, cfg)
+	lasttime := 0
+	marktime := 0
+	part := 1
+
+	for t := range book.Tracks {
+		lasttime = marktime
+		marktime = marktime + int(book.Tracks[t].PlayLength)
+		if marktime > p.SplitTime && part < len(p.Parts) {
+			// todo check it that works, it should but...
+			log.Infof("%s, %s new part %d on %d splittime: %d, maxprt: %d", book.Author, book.Title, part+1, marktime, p.SplitTime, len(p.Parts))
+			marktime = int(book.Tracks[t].PlayLength)
+			lasttime = 0
+			part++
+
+		}
+		// todo ein Kapitel fehlt!
+		p.Parts[part-1] = append(p.Parts[part-1], t)
+		p.Chapters = append(p.Chapters, trackChapters(book, t, part, lasttime, marktime,
+			title(chapterData(p, t, part)), cfg)...)
+		log.Infof("file %d.m4a on %d from %d to %d track duration %d", t, part, lasttime, marktime, int(book.Tracks[t].PlayLength))
+	}
+}
+
+// trackChapters returns the chapters of track t which is at start to
+// end of its part: one named title, or the chapters embedded in the
+// track moved to start and named by cfg.SourceChapters. Embedded
+// chapters past the end of the track are dropped, if that leaves none
+// the track gets the one named title.
+func trackChapters(b *Book, t int, part int, start int, end int, title string, cfg *Config) []Chapter {
+	inner := b.Tracks[t].Chapters
+	if len(inner) == 0 || cfg.SourceChapters == "ignore" {
+		return []Chapter{{Part: part, Track: t, Start: start, End: end, Title: title}}
+	}
+	cs := []Chapter{}
+	for i, c := range inner {
+		s := start + c.Start
+		if s >= end {
+			log.Warnf("%v: chapter %q starts after the end of the track", b.Tracks[t].Filename, c.Title)
+			break
+		}
+		e := end
+		if i+1 < len(inner) && start+inner[i+1].Start < end {
+			e = start + inner[i+1].Start
+		}
+		ct := c.Title
+		switch {
+		case ct == "":
+			ct = title + " " + strconv.Itoa(i+1)
+		case cfg.SourceChapters == "prefix":
+			ct = title + " - " + ct
+		}
+		cs = append(cs, Chapter{Part: part, Track: t, Start: s, End: e, Title: ct})
+	}
+	if len(cs) == 0 {
+		return []Chapter{{Part: part, Track: t, Start: start, End: end, Title: title}}
+	}
+	cs[0].Start = start
+	return cs
+}
+
+func orderedDiskSet(trackset Tracks) []Tracks {
+	// how many disks?
+	// remember the slice starts with 0, disk no starting with 1
+	if withoutDisks(trackset) {
+		disk := make(Tracks, len(trackset))
+		for name, t := range trackset {
+			t.DiskNo, t.MaxDisk = 1, 1
+			disk[name] = t
+		}
+		return []Tracks{disk}
+	}
+	maxdisk := 0
+	for _, t := range trackset {
+		if t.MaxDisk > maxdisk {
+			maxdisk = t.MaxDisk
+		}
+	}
+	if maxdisk == 0 {
+		maxdisk = highestDisk(trackset)
+	}
+	if maxdisk == 0 {
+		log.Warnf("[MaxTrack] No diskset information for this book")
+		return nil
+	}
+	ts := make([]Tracks, maxdisk)
+	for j := 0; j < maxdisk; j++ {
+		ts[j] = make(Tracks)
+	}
+	for track := range trackset {
+		d := trackset[track].DiskNo
+		if d < 1 || d > maxdisk {
+			log.Errorf("[MaxTrack] %v has disk %v of %v", track, d, maxdisk)
+			return nil
+		}
+		ts[d-1][track] = trackset[track]
+	}
+	return ts
+}
+
+// orderedTracksOnBook puts the tracks of each disk in order of track
+// number, tracks with the same or no number in natural order of their
+// file names. It never drops a track. The second result explains the
+// order, one line per track.
+func orderedTracksOnBook(disks []Tracks) ([]Track, []string) {
+	list := []Track{}
+	why := []string{}
+	for j := range disks {
+		t := make([]Track, 0, len(disks[j]))
+		for _, track := range disks[j] {
+			t = append(t, track)
+		}
+		sort.SliceStable(t, func(a, b int) bool {
+			if t[a].TrackNo != t[b].TrackNo {
+				return t[a].TrackNo < t[b].TrackNo
+			}
+			return naturalLess(t[a].Filename, t[b].Filename)
+		})
+		for i, track := range t {
+			reason := "by track number"
+			switch {
+			case track.TrackNo == 0:
+				reason = "no track number, by file name"
+			case i > 0 && t[i-1].TrackNo == track.TrackNo:
+				reason = "same track number as " + t[i-1].Filename + ", by file name"
+			case i > 0 && t[i-1].TrackNo+1 < track.TrackNo:
+				reason = fmt.Sprintf("by track number, %d to %d missing before", t[i-1].TrackNo+1, track.TrackNo-1)
+			case i == 0 && track.TrackNo > 1:
+				reason = fmt.Sprintf("by track number, 1 to %d missing before", track.TrackNo-1)
+			}
+			why = append(why, fmt.Sprintf("%3d. disk %d track %d %s: %s", len(list)+i+1, track.DiskNo, track.TrackNo, track.Filename, reason))
+		}
+		list = append(list, t...)
+	}
+	return list, why
+}
@@ -0,0 +1,47 @@
+package reorg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTrackChapters(t *testing.T) {
+	inner := []TrackChapter{{Start: 0, Title: "Intro"}, {Start: 400, Title: ""}, {Start: 700, Title: "Outro"}}
+	for _, c := range []struct {
+		name   string
+		policy string
+		inner  []TrackChapter
+		want   []Chapter
+	}{
+		{"no chapters", "prefix", nil,
+			[]Chapter{{2, 1, 5000, 6000, "Kapitel 2"}}},
+		{"ignore", "ignore", inner,
+			[]Chapter{{2, 1, 5000, 6000, "Kapitel 2"}}},
+		{"prefix", "prefix", inner, []Chapter{
+			{2, 1, 5000, 5400, "Kapitel 2 - Intro"},
+			{2, 1, 5400, 5700, "Kapitel 2 2"},
+			{2, 1, 5700, 6000, "Kapitel 2 - Outro"}}},
+		{"flatten", "flatten", inner, []Chapter{
+			{2, 1, 5000, 5400, "Intro"},
+			{2, 1, 5400, 5700, "Kapitel 2 2"},
+			{2, 1, 5700, 6000, "Outro"}}},
+		{"first starts late", "flatten", []TrackChapter{{Start: 100, Title: "A"}, {Start: 500, Title: "B"}}, []Chapter{
+			{2, 1, 5000, 5500, "A"},
+			{2, 1, 5500, 6000, "B"}}},
+		{"past the end", "flatten", []TrackChapter{{Start: 0, Title: "A"}, {Start: 1500, Title: "B"}}, []Chapter{
+			{2, 1, 5000, 6000, "A"}}},
+		{"all past the end", "prefix", []TrackChapter{{Start: 1500, Title: "A"}, {Start: 1800, Title: "B"}},
+			[]Chapter{{2, 1, 5000, 6000, "Kapitel 2"}}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			b := testBook(2, 1000)
+			b.Tracks[1].Chapters = c.inner
+			cfg := DefaultConfig()
+			cfg.SourceChapters = c.policy
+			got := trackChapters(b, 1, 2, 5000, 6000, "Kapitel 2", cfg)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %v\nwant %v", got, c.want)
+			}
+		})
+	}
+}
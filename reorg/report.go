@@ -0,0 +1,138 @@
+package reorg
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Report lists everything found by Scan with the verdicts of the
+// integrity checks, for use by other tools
+type Report struct {
+	Books []BookReport `json:"books"`
+}
+
+// BookReport is one author/album of the library
+type BookReport struct {
+	Author    string              `json:"author"`
+	Book      string              `json:"book"`
+	OK        bool                `json:"ok"`
+	Verdict   Verdict             `json:"verdict"`
+	Conflicts map[string][]string `json:"conflicts,omitempty"` // book tags the tracks disagree on
+	FromPath  bool                `json:"from_path,omitempty"` // author or book taken from the folders
+	Tracks    []TrackReport       `json:"tracks"`
+}
+
+// TrackReport is one source file of a book
+type TrackReport struct {
+	Filename string   `json:"filename"`
+	Artist   string   `json:"artist"`
+	Narrator string   `json:"narrator,omitempty"`
+	Title    string   `json:"title"`
+	DiskNo   int      `json:"disk"`
+	MaxDisk  int      `json:"maxdisk"`
+	TrackNo  int      `json:"track"`
+	MaxTrack int      `json:"maxtrack"`
+	Duration int      `json:"duration_ms"`
+	Inferred []string `json:"inferred,omitempty"` // numbers taken from the names, not the tags
+	Findings []string `json:"findings,omitempty"` // of the deep check
+}
+
+// NewReport builds the report, it must be called before the rejected
+// books are removed from the library
+func NewReport(l Library, v Verdicts) *Report {
+	r := &Report{}
+	for _, auth := range l.Authors() {
+		for _, book := range l.Books(auth) {
+			br := BookReport{Author: auth, Book: book, Verdict: v.Get(auth, book)}
+			br.OK = br.Verdict.OK()
+			if _, c := CommonTags(tracksOf(l[auth][book])); len(c) > 0 {
+				br.Conflicts = c
+			}
+			for _, t := range l[auth][book] {
+				br.Tracks = append(br.Tracks, TrackReport{
+					Filename: t.Filename,
+					Artist:   t.Artist,
+					Narrator: t.Narrator,
+					Title:    t.Title,
+					DiskNo:   t.DiskNo,
+					MaxDisk:  t.MaxDisk,
+					TrackNo:  t.TrackNo,
+					MaxTrack: t.MaxTrack,
+					Duration: int(t.PlayLength),
+					Inferred: t.Inferred,
+					Findings: br.Verdict.findingsOf(t.Filename),
+				})
+				if contains(t.Inferred, InferredAuthor) || contains(t.Inferred, InferredBook) {
+					br.FromPath = true
+				}
+			}
+			sort.Slice(br.Tracks, func(i, j int) bool {
+				a, b := br.Tracks[i], br.Tracks[j]
+				if a.DiskNo != b.DiskNo {
+					return a.DiskNo < b.DiskNo
+				}
+				if a.TrackNo != b.TrackNo {
+					return a.TrackNo < b.TrackNo
+				}
+				return a.Filename < b.Filename
+			})
+			r.Books = append(r.Books, br)
+		}
+	}
+	return r
+}
+
+// WriteJSON writes the report as one JSON document
+func (r *Report) WriteJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(r)
+}
+
+// WriteCSV writes one line per track, the book columns are repeated
+func (r *Report) WriteCSV(w io.Writer) error {
+	c := csv.NewWriter(w)
+	c.Write([]string{"author", "book", "ok", "reason", "detail", "filename", "artist", "narrator", "title", "disk", "maxdisk", "track", "maxtrack", "duration_ms", "inferred", "findings", "disk_decision", "from_path", "conflicts"})
+	for _, b := range r.Books {
+		for _, t := range b.Tracks {
+			c.Write([]string{b.Author, b.Book, strconv.FormatBool(b.OK), string(b.Verdict.Reason), b.Verdict.Detail,
+				t.Filename, t.Artist, t.Narrator, t.Title, strconv.Itoa(t.DiskNo), strconv.Itoa(t.MaxDisk),
+				strconv.Itoa(t.TrackNo), strconv.Itoa(t.MaxTrack), strconv.Itoa(t.Duration), strings.Join(t.Inferred, "|"), strings.Join(t.Findings, "|"), b.Verdict.diskDecision(t.DiskNo), strconv.FormatBool(b.FromPath), b.conflictList()})
+		}
+	}
+	c.Flush()
+	return c.Error()
+}
+
+// conflictList is the CSV form of the conflicts, e.g. "genre=Crime|Thriller"
+func (b *BookReport) conflictList() string {
+	l := []string{}
+	for _, k := range conflictKeys(b.Conflicts) {
+		l = append(l, k+"="+strings.Join(b.Conflicts[k], "|"))
+	}
+	return strings.Join(l, "; ")
+}
+
+// WriteFile writes the report as CSV if name ends with .csv, as JSON
+// otherwise
+func (r *Report) WriteFile(name string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(filepath.Ext(name), ".csv") {
+		err = r.WriteCSV(f)
+	} else {
+		err = r.WriteJSON(f)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,212 @@
+package reorg
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dhowden/tag"
+	"github.com/dwbuiten/go-mediainfo/mediainfo"
+	"github.com/h2non/filetype"
+	"github.com/heinrichgrt/m4areorg/mp4meta"
+	log "github.com/sirupsen/logrus"
+)
+
+var mediainfoOnce sync.Once
+
+// Scan walks dir and reads the metadata of all m4a files into a Library.
+// Files which cannot be read are logged and skipped.
+func Scan(ctx context.Context, dir string, cfg *Config) (Library, error) {
+	mediainfoOnce.Do(mediainfo.Init)
+	patterns, err := cfg.pathPatterns()
+	if err != nil {
+		return nil, err
+	}
+	lib := make(Library)
+	over := overrides{}
+	log.Debugf("Filenamae, Artist, Album, Title, Track-No, MaxTrack, Disk-No, MaxDisk, Duration\n")
+	err = filepath.Walk(dir, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if f.IsDir() || !checkType(path) {
+			return nil
+		}
+		t, err := ReadTrack(path)
+		if err != nil {
+			log.Errorf("skipping %v: %v", path, err)
+			return nil
+		}
+		over.apply(&t)
+		assignAuthor(&t, cfg.GroupBy)
+		if !cfg.Strict {
+			inferAuthorBook(&t, dir, patterns)
+		}
+		lib.Add(t)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !cfg.Strict {
+		lib.inferNumbers()
+	}
+	return lib, nil
+}
+
+// ReadTrack reads the tags and the duration of one file
+func ReadTrack(filename string) (Track, error) {
+	stc := Track{}
+	dura, format, err := audioInfo(filename)
+	if err != nil {
+		return stc, err
+	}
+	m, err := readMetaData(filename)
+	if err != nil {
+		return stc, err
+	}
+	log.Infof("filename: %v duration: %v\n", filename, dura)
+
+	tracknotmp, trackmaxtmp := m.Track()
+	disknotmp, maxdisktmp := m.Disc()
+
+	stc.Artist = m.Artist()
+	stc.AlbumArtist = m.AlbumArtist()
+	stc.Composer = m.Composer()
+	stc.Album = m.Album()
+	stc.Title = m.Title()
+	stc.TrackNo = tracknotmp
+	stc.MaxTrack = trackmaxtmp
+	stc.DiskNo = disknotmp
+	stc.MaxDisk = maxdisktmp
+	stc.Tagged = numbersOf(stc)
+	stc.PlayLength = dura
+	stc.Format = format
+	stc.Filename = filename
+	if f, err := mp4meta.Open(filename); err != nil {
+		log.Debugf("cannot read the atoms of %v: %v", filename, err)
+	} else {
+		stc.Tags = bookTags(f)
+		stc.Chapters = sourceChapters(f)
+	}
+	return stc, nil
+}
+
+// GroupByKeys are the tags a library can be grouped by
+var GroupByKeys = []string{"artist", "albumartist", "composer"}
+
+// readBy splits "Author read by Narrator" and the like
+var readBy = regexp.MustCompile(`(?i)^(.+?)[,;]?\s+(?:read by|narrated by|gelesen von|gesprochen von|lu par)\s+(.+)$`)
+
+func splitNarrator(s string) (string, string) {
+	if m := readBy.FindStringSubmatch(s); m != nil {
+		return strings.TrimSpace(m[1]), strings.TrimSpace(m[2])
+	}
+	return s, ""
+}
+
+// assignAuthor sets the author from the first tag of groupBy which is
+// not empty. The narrator is taken from "... read by ..." in the artist,
+// or is the artist if that is not the author.
+func assignAuthor(t *Track, groupBy []string) {
+	artist, narrator := splitNarrator(t.Artist)
+	albumartist, _ := splitNarrator(t.AlbumArtist)
+	t.Author = ""
+	for _, g := range groupBy {
+		switch g {
+		case "artist":
+			t.Author = artist
+		case "albumartist":
+			t.Author = albumartist
+		case "composer":
+			t.Author = t.Composer
+		}
+		if t.Author != "" {
+			break
+		}
+	}
+	if narrator == "" && artist != t.Author {
+		narrator = artist
+	}
+	t.Narrator = narrator
+}
+
+// sourceChapters returns the chapters embedded in a track, nil if there
+// is at most one as that is no better than a chapter for the track
+func sourceChapters(f *mp4meta.File) []TrackChapter {
+	mc, err := f.Chapters()
+	if err != nil {
+		log.Warnf("ignoring the chapters: %v", err)
+		return nil
+	}
+	if len(mc) < 2 {
+		return nil
+	}
+	tc := make([]TrackChapter, len(mc))
+	for i, c := range mc {
+		tc[i] = TrackChapter{Start: int(c.Start.Milliseconds()), Title: c.Title}
+	}
+	return tc
+}
+
+// audioInfo reads the duration and the format of the first audio stream
+func audioInfo(f string) (float32, AudioFormat, error) {
+	info, err := mediainfo.Open(f)
+	if err != nil {
+		return 0, AudioFormat{}, err
+	}
+	defer info.Close()
+	val, err := info.Get("Duration", 0, mediainfo.Audio)
+	if err != nil {
+		return 0, AudioFormat{}, err
+	}
+	timeint, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, AudioFormat{}, err
+	}
+	return float32(timeint), readFormat(info), nil
+}
+
+func readMetaData(file string) (tag.Metadata, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return tag.ReadFrom(f)
+}
+
+func checkType(filename string) bool {
+
+	// golang detects m4a audio as video/mp4 no idea why
+	f, err := os.Open(filename)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	// the header is all filetype needs
+	buf := make([]byte, 262)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return false
+	}
+
+	kind, err := filetype.Match(buf[:n])
+	if err != nil {
+		return false
+	}
+	switch kind.Extension {
+	case "mp4", "m4a":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package reorg
+
+import "testing"
+
+func TestSplitNarrator(t *testing.T) {
+	for _, c := range []struct {
+		in, author, narrator string
+	}{
+		{"Stephen King", "Stephen King", ""},
+		{"Stephen King read by Frank Muller", "Stephen King", "Frank Muller"},
+		{"Stephen King, Read By Frank Muller", "Stephen King", "Frank Muller"},
+		{"J. K. Rowling; narrated by Stephen Fry", "J. K. Rowling", "Stephen Fry"},
+		{"Walter Moers gelesen von Dirk Bach", "Walter Moers", "Dirk Bach"},
+		{"Thomas Mann gesprochen von Gert Westphal", "Thomas Mann", "Gert Westphal"},
+		{"Victor Hugo lu par Denis Podalydès", "Victor Hugo", "Denis Podalydès"},
+		{"read by Frank Muller", "read by Frank Muller", ""},
+		{"", "", ""},
+	} {
+		author, narrator := splitNarrator(c.in)
+		if author != c.author || narrator != c.narrator {
+			t.Errorf("%q: %q %q, want %q %q", c.in, author, narrator, c.author, c.narrator)
+		}
+	}
+}
+
+func TestAssignAuthor(t *testing.T) {
+	tr := Track{Artist: "King read by Muller", AlbumArtist: "Stephen King", Composer: "Frank Muller"}
+	for _, c := range []struct {
+		name             string
+		track            Track
+		groupBy          []string
+		author, narrator string
+	}{
+		{"artist", tr, []string{"artist"}, "King", "Muller"},
+		{"albumartist", tr, []string{"albumartist"}, "Stephen King", "Muller"},
+		{"composer", tr, []string{"composer"}, "Frank Muller", "Muller"},
+		{"first set wins", tr, []string{"albumartist", "artist"}, "Stephen King", "Muller"},
+		{"empty falls back", Track{Artist: "King"}, []string{"albumartist", "composer", "artist"}, "King", ""},
+		{"artist is narrator", Track{Artist: "Frank Muller", AlbumArtist: "Stephen King"}, []string{"albumartist"},
+			"Stephen King", "Frank Muller"},
+		{"albumartist read by", Track{AlbumArtist: "Stephen King read by Frank Muller"}, []string{"albumartist"},
+			"Stephen King", ""},
+		// nothing to group by: the artist silently becomes the narrator
+		{"group by tag empty", Track{Artist: "Jane Roe"}, []string{"albumartist"}, "", "Jane Roe"},
+		{"nothing set", Track{}, []string{"artist", "albumartist"}, "", ""},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			tr := c.track
+			tr.Author = "old"
+			assignAuthor(&tr, c.groupBy)
+			if tr.Author != c.author || tr.Narrator != c.narrator {
+				t.Errorf("author %q narrator %q, want %q %q", tr.Author, tr.Narrator, c.author, c.narrator)
+			}
+		})
+	}
+}
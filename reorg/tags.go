@@ -0,0 +1,94 @@
+package reorg
+
+import (
+	"sort"
+
+	"github.com/heinrichgrt/m4areorg/mp4meta"
+)
+
+// BookTag is a tag which belongs to the book rather than to a track and
+// is carried into the joined file if all tracks agree on it
+type BookTag struct {
+	Key  string // the FFMETADATA key
+	Atom string // the mp4meta key it is read from
+}
+
+// BookTags are the tags read from the source tracks. Language is not an
+// ilst atom of its own, it is read with mp4meta.File.Language.
+var BookTags = []BookTag{
+	{"genre", mp4meta.Genre},
+	{"date", mp4meta.Year},
+	{"album_artist", mp4meta.AlbumArtist},
+	{"composer", mp4meta.Composer},
+	{"copyright", mp4meta.Copyright},
+	{"publisher", mp4meta.Publisher},
+	{"comment", mp4meta.Comment},
+	{"description", mp4meta.Description},
+	{"synopsis", mp4meta.LongDesc},
+	{"language", mp4meta.LanguageKey},
+	{"sort_album", mp4meta.SortAlbum},
+	{"sort_artist", mp4meta.SortArtist},
+	{"sort_album_artist", mp4meta.SortAlbumAr},
+	{"sort_composer", mp4meta.SortCompose},
+}
+
+// bookTags returns the BookTags set in f
+func bookTags(f *mp4meta.File) map[string]string {
+	tags := map[string]string{}
+	for _, bt := range BookTags {
+		v := f.Text(bt.Atom)
+		if bt.Atom == mp4meta.LanguageKey {
+			v = f.Language()
+		}
+		if v != "" {
+			tags[bt.Key] = v
+		}
+	}
+	return tags
+}
+
+// CommonTags returns the book tags all tracks agree on. Tracks without
+// the tag do not count. If the tracks disagree the tag is left out and
+// the distinct values are returned as conflict.
+func CommonTags(tracks []Track) (map[string]string, map[string][]string) {
+	values := map[string]map[string]bool{}
+	for _, t := range tracks {
+		for k, v := range t.Tags {
+			if values[k] == nil {
+				values[k] = map[string]bool{}
+			}
+			values[k][v] = true
+		}
+	}
+	tags := map[string]string{}
+	conflicts := map[string][]string{}
+	for k, vs := range values {
+		l := sortedKeys(vs)
+		if len(l) == 1 {
+			tags[k] = l[0]
+		} else {
+			conflicts[k] = l
+		}
+	}
+	return tags, conflicts
+}
+
+// tracksOf returns the tracks of a book in filename order
+func tracksOf(tracks Tracks) []Track {
+	l := make([]Track, 0, len(tracks))
+	for _, f := range sortedKeys(tracks) {
+		l = append(l, tracks[f])
+	}
+	return l
+}
+
+// conflictKeys returns the keys of the conflicts in the order of BookTags
+func conflictKeys(conflicts map[string][]string) []string {
+	keys := sortedKeys(conflicts)
+	order := map[string]int{}
+	for i, bt := range BookTags {
+		order[bt.Key] = i
+	}
+	sort.SliceStable(keys, func(i, j int) bool { return order[keys[i]] < order[keys[j]] })
+	return keys
+}
@@ -0,0 +1,132 @@
+package reorg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/heinrichgrt/m4areorg/mp4meta"
+	log "github.com/sirupsen/logrus"
+)
+
+// Tools are the operations on audio files the Joiner needs
+type Tools interface {
+	// Concat joins the files of the ffmpeg concat list into target and
+	// applies the chapters and tags of the FFMETADATA file
+	Concat(ctx context.Context, list string, metadata string, target string) error
+	// Tag writes the cover, the media kind and text atoms to target
+	Tag(ctx context.Context, target string, tags TagSet) error
+	// Convert re-encodes the audio of src to target in format f
+	Convert(ctx context.Context, src string, target string, f AudioFormat) error
+	// Decode decodes the audio of file completely without writing it. An
+	// error means the decoder could not run, problems of the file are
+	// in Decoded.Errors.
+	Decode(ctx context.Context, file string) (Decoded, error)
+}
+
+// Decoded is the outcome of Tools.Decode
+type Decoded struct {
+	Duration int    // decoded length in ms, 0 if unknown
+	Errors   string // what the decoder complained about, empty if nothing
+}
+
+// TagSet are the changes Tag makes to a file in one go
+type TagSet struct {
+	Cover     []byte            // JPEG or PNG, nil leaves the cover alone
+	MediaKind mp4meta.MediaKind // 0 leaves the media kind alone
+	Text      map[string]string // ilst text atoms, e.g. mp4meta.Title
+}
+
+// ExecTools runs ffmpeg found in BinPath, the tags are read and written
+// by mp4meta
+type ExecTools struct {
+	BinPath string
+}
+
+// NewExecTools returns the real tools found in binpath
+func NewExecTools(binpath string) *ExecTools {
+	return &ExecTools{BinPath: binpath}
+}
+
+func (e *ExecTools) run(ctx context.Context, name string, args ...string) error {
+	cmd := e.BinPath + "/" + name
+	if out, err := exec.CommandContext(ctx, cmd, args...).CombinedOutput(); err != nil {
+		log.Debugf("%v output: %s", name, out)
+		return fmt.Errorf("%v %v: %w", cmd, args, err)
+	}
+	return nil
+}
+
+// Concat runs ffmpeg with the concat demuxer
+func (e *ExecTools) Concat(ctx context.Context, list string, metadata string, target string) error {
+	return e.run(ctx, "ffmpeg", "-f", "concat", "-y", "-safe", "1", "-i", list, "-i", metadata,
+		"-map_metadata", "1", "-vn", "-c:a", "copy", "-movflags", "faststart", target)
+}
+
+// Convert runs ffmpeg with the encoder of f, the tags are kept
+func (e *ExecTools) Convert(ctx context.Context, src string, target string, f AudioFormat) error {
+	enc, err := encoderArgs(f)
+	if err != nil {
+		return err
+	}
+	args := append([]string{"-nostdin", "-y", "-i", src, "-vn", "-map_metadata", "0"}, enc...)
+	return e.run(ctx, "ffmpeg", append(args, target)...)
+}
+
+// Decode runs ffmpeg with the null muxer. The errors are the output of
+// ffmpeg at log level error, the duration is taken from its progress.
+func (e *ExecTools) Decode(ctx context.Context, file string) (Decoded, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, e.BinPath+"/ffmpeg", "-nostdin", "-v", "error", "-i", file,
+		"-vn", "-f", "null", "-progress", "pipe:1", "-")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return Decoded{}, ctx.Err()
+	}
+	var exit *exec.ExitError
+	if err != nil && !errors.As(err, &exit) {
+		return Decoded{}, err
+	}
+	d := Decoded{Errors: strings.TrimSpace(stderr.String())}
+	if err != nil && d.Errors == "" {
+		d.Errors = err.Error()
+	}
+	// the last out_time_us is the end of the decoded audio
+	for _, l := range strings.Split(stdout.String(), "\n") {
+		if v := strings.TrimPrefix(l, "out_time_us="); v != l {
+			if us, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil && us > 0 {
+				d.Duration = int(us / 1000)
+			}
+		}
+	}
+	return d, nil
+}
+
+// Tag rewrites the metadata of target with mp4meta
+func (e *ExecTools) Tag(ctx context.Context, target string, tags TagSet) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f, err := mp4meta.Open(target)
+	if err != nil {
+		return err
+	}
+	if tags.Cover != nil {
+		if err := f.SetCover(tags.Cover); err != nil {
+			return err
+		}
+	}
+	if tags.MediaKind != 0 {
+		f.SetMediaKind(tags.MediaKind)
+	}
+	for k, v := range tags.Text {
+		f.SetText(k, v)
+	}
+	return f.Save()
+}
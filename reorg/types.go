@@ -0,0 +1,133 @@
+// Package reorg scans a directory of m4a audio book tracks, checks the
+// books for completeness, plans how they are split into parts and joins
+// them with ffmpeg.
+package reorg
+
+import (
+	"sort"
+	"strings"
+)
+
+// Track is the metadata of one source file
+type Track struct {
+	Author      string // the grouping key, see Config.GroupBy
+	Narrator    string
+	Artist      string
+	AlbumArtist string
+	Composer    string
+	Album       string
+	Title       string
+	TrackNo     int
+	MaxTrack    int
+	DiskNo      int
+	MaxDisk     int
+	PlayLength  float32 // in ms
+	Format      AudioFormat
+	Filename    string
+	Tags        map[string]string // BookTags by FFMETADATA key
+	Chapters    []TrackChapter    // embedded in the file, nil if none
+	Inferred    []string          // numbers not from the tags, e.g. InferredTrack
+	Tagged      Numbers           // the numbers in the tags, before overrides and inference
+}
+
+// TrackChapter is a chapter mark found in a source file
+type TrackChapter struct {
+	Start int // in ms from the start of the track
+	Title string
+}
+
+// Tracks of one book, keyed by filename
+type Tracks map[string]Track
+
+// Books of one author, keyed by album
+type Books map[string]Tracks
+
+// Library is everything found by Scan, keyed by author
+type Library map[string]Books
+
+// Add inserts a track into the library
+func (l Library) Add(t Track) {
+	if l[t.Author] == nil {
+		l[t.Author] = make(Books)
+	}
+	if l[t.Author][t.Album] == nil {
+		l[t.Author][t.Album] = make(Tracks)
+	}
+	l[t.Author][t.Album][t.Filename] = t
+}
+
+// Authors returns the sorted list of authors
+func (l Library) Authors() []string {
+	return sortedKeys(l)
+}
+
+// Books returns the sorted list of books of an author
+func (l Library) Books(author string) []string {
+	return sortedKeys(l[author])
+}
+
+// Remove drops a book, and the author when there is nothing left
+func (l Library) Remove(author string, book string) {
+	delete(l[author], book)
+	if len(l[author]) == 0 {
+		delete(l, author)
+	}
+}
+
+// PlayTime is the sum of all track lengths in ms
+func (a Tracks) PlayTime() int {
+	d := 0
+	for t := range a {
+		d += int(a[t].PlayLength)
+	}
+	return d
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func getSomeKey(m Tracks) string {
+	for k := range m {
+		return k
+	}
+	return ""
+}
+
+// naturalLess compares strings with runs of digits by their value, so
+// "track 2" comes before "track 10"
+func naturalLess(a string, b string) bool {
+	for a != "" && b != "" {
+		da, db := digits(a), digits(b)
+		switch {
+		case da > 0 && db > 0:
+			na, nb := strings.TrimLeft(a[:da], "0"), strings.TrimLeft(b[:db], "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			a, b = a[da:], b[db:]
+		case a[0] != b[0]:
+			return a[0] < b[0]
+		default:
+			a, b = a[1:], b[1:]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// digits is the length of the run of digits s starts with
+func digits(s string) int {
+	n := 0
+	for n < len(s) && s[n] >= '0' && s[n] <= '9' {
+		n++
+	}
+	return n
+}